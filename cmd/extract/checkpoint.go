@@ -0,0 +1,224 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Checkpoint records how far an extraction has progressed, so that
+// an interrupted run can be resumed for the same dump. Entities is
+// the number of entities, counted in dump order, whose names have
+// been appended to the spool files of the outputs; Spools tells the
+// size of each spool file at that point. Position tells where the
+// next entity starts in the compressed dump, so a resumed run can
+// seek there. Without a Position, such as when the dump reader could
+// not tell it yet, a resumed run reads the dump from its start and
+// skips the first Entities entities.
+//
+// Spools are keyed by the output name for its names, and by the output
+// name plus "-items", "-rejected" or "-bearers" for the items of the
+// "ndjson" format, the names rejected by filters, and the references
+// of name bearers. The spool of the persons file is keyed "persons".
+// SpoolKeys lists the keys that the configuration calls for. A run can
+// only resume from a checkpoint with the same Format, Config and
+// SpoolKeys, because otherwise the spools would mix records of
+// different layouts or configurations.
+type Checkpoint struct {
+	Format    int              `json:"format"`
+	DumpPath  string           `json:"dump_path"`
+	Config    string           `json:"config"`
+	Outputs   []string         `json:"outputs"`
+	SpoolKeys []string         `json:"spool_keys"`
+	Entities  int64            `json:"entities"`
+	Position  *DumpPosition    `json:"position,omitempty"`
+	Spools    map[string]int64 `json:"spools"`
+}
+
+// checkpointFormat is the version of the spool record layouts. It must
+// be increased whenever the extractor changes what it spools, such as
+// when the bearer records got the sex or gender and decade of birth.
+const checkpointFormat = 2
+
+func checkpointPath(workdir string, dumpDate time.Time) string {
+	day := dumpDate.Format("20060102")
+	return filepath.Join(workdir, fmt.Sprintf("checkpoint-%s.json", day))
+}
+
+func spoolPath(workdir string, name string, dumpDate time.Time) string {
+	day := dumpDate.Format("20060102")
+	return filepath.Join(workdir, fmt.Sprintf("%s-%s.spool", name, day))
+}
+
+// NewCheckpoint returns a checkpoint for a run that has not yet
+// processed any entities.
+func NewCheckpoint(config *Config, dumpPath string) *Checkpoint {
+	cp := &Checkpoint{
+		Format:    checkpointFormat,
+		DumpPath:  dumpPath,
+		Config:    configHash(config),
+		Outputs:   make([]string, 0, len(config.Outputs)),
+		SpoolKeys: spoolKeys(config),
+		Spools:    make(map[string]int64, len(config.Outputs)),
+	}
+	for _, o := range config.Outputs {
+		cp.Outputs = append(cp.Outputs, o.Name)
+	}
+	return cp
+}
+
+// spoolKeys returns the keys of the spools that a run with a given
+// configuration writes, in sorted order. This needs to be kept in
+// sync with NewOutput and NewPersons.
+func spoolKeys(config *Config) []string {
+	var keys []string
+	for _, o := range config.Outputs {
+		keys = append(keys, o.Name)
+		if slices.Contains(o.Formats, "ndjson") {
+			keys = append(keys, o.Name+"-items")
+		}
+		if len(config.Filters) > 0 {
+			keys = append(keys, o.Name+"-rejected")
+		}
+		if o.Bearers != "" {
+			keys = append(keys, o.Name+"-bearers")
+		}
+	}
+	if config.Persons {
+		keys = append(keys, personsName)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// configHash returns a hex-encoded SHA-256 hash of a configuration,
+// which changes whenever the configuration does.
+func configHash(config *Config) string {
+	// Marshaling cannot fail for a struct of strings and slices.
+	data, _ := json.Marshal(config)
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// ReadCheckpoint reads the checkpoint for a dump date. If there is
+// none, the result is nil without an error.
+func ReadCheckpoint(workdir string, dumpDate time.Time) (*Checkpoint, error) {
+	data, err := os.ReadFile(checkpointPath(workdir, dumpDate))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("checkpoint for %s: %v", dumpDate.Format("2006-01-02"), err)
+	}
+	return &cp, nil
+}
+
+// Matches reports whether a checkpoint was written by a run with the
+// same spool layout and configuration for the same dump. Otherwise,
+// it is not safe to resume from it.
+func (cp *Checkpoint) Matches(other *Checkpoint) bool {
+	return cp.Format == other.Format &&
+		cp.DumpPath == other.DumpPath &&
+		cp.Config == other.Config &&
+		slices.Equal(cp.Outputs, other.Outputs) &&
+		slices.Equal(cp.SpoolKeys, other.SpoolKeys)
+}
+
+// Write stores a checkpoint atomically, so that a crash while writing
+// cannot leave a truncated file behind.
+func (cp *Checkpoint) Write(workdir string, dumpDate time.Time) error {
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(checkpointPath(workdir, dumpDate), data)
+}
+
+// writeFileAtomic writes a file under a temporary name and renames it
+// into place, so that readers never see a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// CleanStale removes the temporary files that interrupted runs
+// have left in workdir, together with the checkpoints and spool
+// files of dumps other than the one at dumpDate. Only files that
+// the extractor itself writes for the given configuration get
+// removed; anything else in workdir is left alone.
+func CleanStale(config *Config, workdir string, dumpDate time.Time) error {
+	entries, err := os.ReadDir(workdir)
+	if err != nil {
+		return err
+	}
+
+	day := dumpDate.Format("20060102")
+	for _, e := range entries {
+		name := e.Name()
+		if !e.Type().IsRegular() || !ownedFile(config, name) {
+			continue
+		}
+		stale := strings.HasSuffix(name, ".tmp")
+		if strings.HasSuffix(name, ".spool") && !strings.HasSuffix(name, "-"+day+".spool") {
+			stale = true
+		}
+		if strings.HasPrefix(name, "checkpoint-") && name != "checkpoint-"+day+".json" {
+			stale = true
+		}
+		if stale {
+			if err := os.Remove(filepath.Join(workdir, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ownedFile tells whether a file in the working directory has been
+// written by an extractor with a given configuration. This needs to be
+// kept in sync with the paths of the files that the extractor writes.
+func ownedFile(config *Config, name string) bool {
+	prefixes := []string{
+		"checkpoint", "manifest", personsName, rejectedName,
+		"name-countries", "name-decades", "name-genders", "subclasses",
+	}
+	for _, o := range config.Outputs {
+		prefixes = append(prefixes, o.Name)
+	}
+	for _, p := range prefixes {
+		if strings.HasPrefix(name, p+"-") {
+			return true
+		}
+	}
+	return false
+}
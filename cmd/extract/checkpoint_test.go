@@ -0,0 +1,145 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckpoint(t *testing.T) {
+	workdir := t.TempDir()
+	dumpDate, _ := time.Parse(time.RFC3339, "2023-04-18T23:22:21Z")
+	config, err := ReadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cp, err := ReadCheckpoint(workdir, dumpDate); cp != nil || err != nil {
+		t.Errorf("expected no checkpoint, got %v %v", cp, err)
+	}
+
+	cp := NewCheckpoint(config, "dump.json.bz2")
+	cp.Entities = 7
+	cp.Spools["givennames"] = 1234
+	if err := cp.Write(workdir, dumpDate); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadCheckpoint(workdir, dumpDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Entities != 7 || got.Spools["givennames"] != 1234 {
+		t.Errorf("got %v", got)
+	}
+	if !got.Matches(NewCheckpoint(config, "dump.json.bz2")) {
+		t.Error("expected checkpoint to match same dump and config")
+	}
+	if got.Matches(NewCheckpoint(config, "other.json.bz2")) {
+		t.Error("expected checkpoint to not match other dump")
+	}
+	config.Outputs = config.Outputs[:1]
+	if got.Matches(NewCheckpoint(config, "dump.json.bz2")) {
+		t.Error("expected checkpoint to not match other outputs")
+	}
+}
+
+func TestCheckpointMatches(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		change func(config *Config)
+	}{
+		{"ndjson", func(config *Config) { config.Outputs[0].Formats = nil }},
+		{"filters", func(config *Config) { config.Filters = nil }},
+		{"bearers", func(config *Config) { config.Outputs[1].Bearers = "" }},
+		{"persons", func(config *Config) { config.Persons = false }},
+		{"sources", func(config *Config) { config.Outputs[0].Sources = []string{"label"} }},
+	} {
+		config, err := ReadConfig("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		old := NewCheckpoint(config, "dump.json.bz2")
+		tc.change(config)
+		if old.Matches(NewCheckpoint(config, "dump.json.bz2")) {
+			t.Errorf("%s: expected checkpoint to not match changed config", tc.name)
+		}
+	}
+
+	// A checkpoint of an earlier version, whose spools may have
+	// another record layout.
+	config, err := ReadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := NewCheckpoint(config, "dump.json.bz2")
+	old.Format = checkpointFormat - 1
+	if old.Matches(NewCheckpoint(config, "dump.json.bz2")) {
+		t.Error("expected checkpoint to not match other format")
+	}
+}
+
+func TestSpoolKeys(t *testing.T) {
+	config, err := ReadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(spoolKeys(config), " ")
+	want := "familynames familynames-bearers familynames-items familynames-rejected " +
+		"givennames givennames-bearers givennames-items givennames-rejected persons"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCleanStale(t *testing.T) {
+	workdir := t.TempDir()
+	dumpDate, _ := time.Parse(time.RFC3339, "2023-04-18T23:22:21Z")
+	for _, f := range []string{
+		"checkpoint-20230411.json",
+		"checkpoint-20230418.json",
+		"familynames-20230411.csv.gz",
+		"familynames-20230418.csv.gz.tmp",
+		"givennames-20230411.spool",
+		"givennames-20230418.spool",
+		"notes-20230411.spool",
+		"notes.txt.tmp",
+		"subclasses-Q202444.csv",
+		"subclasses-Q202444.csv.tmp",
+	} {
+		if err := os.WriteFile(filepath.Join(workdir, f), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config, err := ReadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CleanStale(config, workdir, dumpDate); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(workdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make([]string, 0, len(entries))
+	for _, e := range entries {
+		files = append(files, e.Name())
+	}
+	sort.Strings(files)
+	got := strings.Join(files, " ")
+	want := "checkpoint-20230418.json familynames-20230411.csv.gz " +
+		"givennames-20230418.spool notes-20230411.spool notes.txt.tmp " +
+		"subclasses-Q202444.csv"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,108 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+// ClassTree holds the "subclass of" (P279) relations between Wikidata
+// classes, as found in a dump. Unlike the Wikidata Query Service,
+// the tree reflects exactly the same point in time as the dump.
+type ClassTree struct {
+	mutex    sync.Mutex
+	children map[int64][]int64
+}
+
+func NewClassTree() *ClassTree {
+	return &ClassTree{children: make(map[int64][]int64, 100000)}
+}
+
+// Add records the "subclass of" (P279) claims of an entity.
+// It is safe to call Add from multiple goroutines.
+func (t *ClassTree) Add(e *mediawiki.Entity) {
+	parents := ItemClaims(e, "P279")
+	if len(parents) == 0 {
+		return
+	}
+	child, err := strconv.ParseInt(e.ID[1:], 10, 64)
+	if err != nil {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, parent := range parents {
+		t.children[parent] = append(t.children[parent], child)
+	}
+}
+
+func (t *ClassTree) Subclasses(classID int64) (ClassSet, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	result := make(ClassSet, 500)
+	result[classID] = struct{}{}
+	queue := []int64{classID}
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		for _, sub := range t.children[c] {
+			if _, seen := result[sub]; !seen {
+				result[sub] = struct{}{}
+				queue = append(queue, sub)
+			}
+		}
+	}
+	return result, nil
+}
+
+// ReadClassTree builds a ClassTree from a Wikidata entities dump.
+// To keep this first pass cheap, we only decode the P279 claims
+// of every entity and skip everything else.
+func ReadClassTree(dumpPath string) (*ClassTree, error) {
+	tree := NewClassTree()
+	err := mediawiki.Process(
+		context.Background(),
+		&mediawiki.ProcessConfig[classEntity]{
+			Path:        dumpPath,
+			FileType:    mediawiki.JSONArray,
+			Compression: mediawiki.BZIP2,
+			Process: func(_ context.Context, c classEntity) errors.E {
+				if len(c.Claims.P279) > 0 {
+					e := mediawiki.Entity{
+						ID:     c.ID,
+						Claims: map[string][]mediawiki.Statement{"P279": c.Claims.P279},
+					}
+					tree.Add(&e)
+				}
+				return nil
+			},
+		})
+	if err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// The part of an entity that is needed for building a ClassTree.
+type classEntity struct {
+	ID     string `json:"id"`
+	Claims struct {
+		P279 []mediawiki.Statement `json:"P279"`
+	} `json:"claims"`
+}
+
+// UnmarshalJSON ignores all fields other than those in classEntity.
+// Without this, mediawiki.Process would reject them as unknown.
+func (c *classEntity) UnmarshalJSON(b []byte) error {
+	type plain classEntity
+	return json.Unmarshal(b, (*plain)(c))
+}
@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+func TestReadClassTree(t *testing.T) {
+	tree, err := ReadClassTree(filepath.Join("testdata", "classes", "entities.json.bz2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		classID int64
+		want    string
+	}{
+		{202444, "Q202444,Q3409032,Q11879590,Q12308941"},
+		{101352, "Q101352,Q98775491"},
+		{11879590, "Q3409032,Q11879590"},
+		{5, "Q5"},
+		{777, "Q777"},
+	} {
+		got, err := tree.Subclasses(tc.classID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := formatClassSet(got); s != tc.want {
+			t.Errorf("Subclasses(%d): got %s, want %s", tc.classID, s, tc.want)
+		}
+	}
+}
+
+func TestClassTreeCycle(t *testing.T) {
+	tree := NewClassTree()
+	for _, c := range []struct {
+		id     string
+		parent string
+	}{
+		{"Q1", "Q3"},
+		{"Q2", "Q1"},
+		{"Q3", "Q2"},
+	} {
+		e := mediawiki.Entity{
+			ID: c.id,
+			Claims: map[string][]mediawiki.Statement{
+				"P279": {claim(mediawiki.WikiBaseEntityIDValue{ID: c.parent})},
+			},
+		}
+		tree.Add(&e)
+	}
+
+	got, err := tree.Subclasses(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := formatClassSet(got); s != "Q1,Q2,Q3" {
+		t.Errorf("got %s, want Q1,Q2,Q3", s)
+	}
+}
+
+func formatClassSet(set ClassSet) string {
+	ids := make([]int64, 0, len(set))
+	for id, _ := range set {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	s := make([]string, 0, len(ids))
+	for _, id := range ids {
+		s = append(s, fmt.Sprintf("Q%d", id))
+	}
+	return strings.Join(s, ",")
+}
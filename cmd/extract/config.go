@@ -0,0 +1,190 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"github.com/brawer/wikidata-names/v2/internal/defaultconfig"
+)
+
+// The configuration that gets used when no -config flag is passed.
+var defaultConfig = defaultconfig.JSON
+
+// Config tells which outputs to extract from Wikidata. If Persons
+// is set, the extractor also writes how humans combine the names
+// of the outputs that count bearers, such as given and family names.
+type Config struct {
+	Outputs []OutputConfig `json:"outputs"`
+	Filters []FilterConfig `json:"filters,omitempty"`
+	Persons bool           `json:"persons,omitempty"`
+}
+
+// OutputConfig describes one output, such as "givennames".
+//
+// An entity goes into the output if it is an instance of any of the
+// Classes, or of their transitive subclasses, unless it is an instance
+// of one of the Exclude classes or their subclasses. Sources lists
+// where names get taken from: "label", "alias", or the ID of a property
+// such as "P1705" (native label). Description is shown to people who
+// browse the downloads. Formats lists additional file formats, "parquet",
+// "sqlite" or "ndjson", that get written alongside the gzipped CSV file.
+// Unlike the others, the "ndjson" format has one line per item.
+// If Bearers is set to a property such as "P735" (given name), the
+// output counts how many humans point to each of its items with
+// that property.
+type OutputConfig struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Classes     []string `json:"classes"`
+	Exclude     []string `json:"exclude,omitempty"`
+	Sources     []string `json:"sources"`
+	Formats     []string `json:"formats,omitempty"`
+	Bearers     string   `json:"bearers,omitempty"`
+}
+
+// The file formats that can be listed in OutputConfig.Formats,
+// with the file extension for each.
+var outputFormats = map[string]string{
+	"ndjson":  "ndjson.gz",
+	"parquet": "parquet",
+	"sqlite":  "sqlite",
+}
+
+var (
+	outputNamePattern = regexp.MustCompile(`^[a-zA-Z\d_]+$`)
+	classPattern      = regexp.MustCompile(`^Q[1-9]\d*$`)
+	sourcePattern     = regexp.MustCompile(`^(label|alias|P[1-9]\d*)$`)
+	propertyPattern   = regexp.MustCompile(`^P[1-9]\d*$`)
+)
+
+// ReadConfig reads a configuration file. If path is empty,
+// we return the default configuration.
+func ReadConfig(path string) (*Config, error) {
+	data, name := defaultConfig, "built-in config.json"
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+		name = path
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return &config, nil
+}
+
+func (c *Config) validate() error {
+	if len(c.Outputs) == 0 {
+		return fmt.Errorf("no outputs configured")
+	}
+
+	names := make(map[string]bool, len(c.Outputs))
+	for _, o := range c.Outputs {
+		if !outputNamePattern.MatchString(o.Name) {
+			return fmt.Errorf("bad output name %q", o.Name)
+		}
+		if o.Name == rejectedName || o.Name == personsName {
+			return fmt.Errorf("reserved output name %q", o.Name)
+		}
+		if names[o.Name] {
+			return fmt.Errorf("duplicate output %q", o.Name)
+		}
+		names[o.Name] = true
+
+		if len(o.Classes) == 0 {
+			return fmt.Errorf("output %q: no classes", o.Name)
+		}
+		for _, classes := range [][]string{o.Classes, o.Exclude} {
+			for _, c := range classes {
+				if !classPattern.MatchString(c) {
+					return fmt.Errorf("output %q: bad class %q", o.Name, c)
+				}
+			}
+		}
+
+		if len(o.Sources) == 0 {
+			return fmt.Errorf("output %q: no sources", o.Name)
+		}
+		for _, s := range o.Sources {
+			if !sourcePattern.MatchString(s) {
+				return fmt.Errorf("output %q: bad source %q", o.Name, s)
+			}
+		}
+
+		if o.Bearers != "" && !propertyPattern.MatchString(o.Bearers) {
+			return fmt.Errorf("output %q: bad bearers property %q", o.Name, o.Bearers)
+		}
+
+		formats := make(map[string]bool, len(o.Formats))
+		for _, f := range o.Formats {
+			if _, ok := outputFormats[f]; !ok {
+				return fmt.Errorf("output %q: unsupported format %q", o.Name, f)
+			}
+			if formats[f] {
+				return fmt.Errorf("output %q: duplicate format %q", o.Name, f)
+			}
+			formats[f] = true
+		}
+	}
+
+	if c.Persons && !slices.ContainsFunc(c.Outputs, func(o OutputConfig) bool { return o.Bearers != "" }) {
+		return fmt.Errorf("persons: no output has bearers")
+	}
+
+	rules := make(map[string]bool, len(c.Filters))
+	for _, f := range c.Filters {
+		rule, ok := filterRules[f.Rule]
+		if !ok {
+			return fmt.Errorf("unknown filter rule %q", f.Rule)
+		}
+		if rules[f.Rule] {
+			return fmt.Errorf("duplicate filter rule %q", f.Rule)
+		}
+		rules[f.Rule] = true
+		switch f.Action {
+		case "reject":
+		case "repair":
+			if rule.repair == nil {
+				return fmt.Errorf("filter rule %q: cannot repair", f.Rule)
+			}
+		default:
+			return fmt.Errorf("filter rule %q: bad action %q", f.Rule, f.Action)
+		}
+	}
+
+	return nil
+}
+
+// ClassIDs returns the numeric IDs of the root classes of an output.
+func (o *OutputConfig) ClassIDs() []int64 {
+	return parseClassIDs(o.Classes)
+}
+
+// ExcludeIDs returns the numeric IDs of the excluded classes of an output.
+func (o *OutputConfig) ExcludeIDs() []int64 {
+	return parseClassIDs(o.Exclude)
+}
+
+// Parses IDs such as "Q5", which have already been checked by validate().
+func parseClassIDs(classes []string) []int64 {
+	result := make([]int64, 0, len(classes))
+	for _, c := range classes {
+		if id, err := strconv.ParseInt(c[1:], 10, 64); err == nil {
+			result = append(result, id)
+		}
+	}
+	return result
+}
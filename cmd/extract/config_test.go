@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadConfigDefault(t *testing.T) {
+	config, err := ReadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, o := range config.Outputs {
+		got = append(got, o.Name+":"+strings.Join(o.Classes, ","))
+	}
+	if s := strings.Join(got, " "); s != "familynames:Q101352 givennames:Q202444" {
+		t.Errorf("got %q", s)
+	}
+}
+
+func TestReadConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		config string
+		want   string
+	}{
+		{`{"outputs": []}`, "no outputs configured"},
+		{`{"outputs": [{"name": "a-b", "classes": ["Q5"], "sources": ["label"]}]}`, `bad output name "a-b"`},
+		{`{"outputs": [{"name": "x", "classes": [], "sources": ["label"]}]}`, `output "x": no classes`},
+		{`{"outputs": [{"name": "x", "classes": ["5"], "sources": ["label"]}]}`, `output "x": bad class "5"`},
+		{`{"outputs": [{"name": "x", "classes": ["Q5"], "exclude": ["Q0"], "sources": ["label"]}]}`, `output "x": bad class "Q0"`},
+		{`{"outputs": [{"name": "x", "classes": ["Q5"], "sources": []}]}`, `output "x": no sources`},
+		{`{"outputs": [{"name": "x", "classes": ["Q5"], "sources": ["sitelink"]}]}`, `output "x": bad source "sitelink"`},
+		{`{"outputs": [{"name": "x", "classes": ["Q5"], "sources": ["label"], "formats": ["xls"]}]}`, `output "x": unsupported format "xls"`},
+		{`{"outputs": [{"name": "x", "classes": ["Q5"], "sources": ["label"], "formats": ["sqlite", "sqlite"]}]}`, `output "x": duplicate format "sqlite"`},
+		{`{"outputs": [{"name": "x", "classes": ["Q5"], "sources": ["label"], "bearers": "735"}]}`, `output "x": bad bearers property "735"`},
+		{`{"outputs": [{"name": "rejected", "classes": ["Q5"], "sources": ["label"]}]}`, `reserved output name "rejected"`},
+		{`{"outputs": [{"name": "persons", "classes": ["Q5"], "sources": ["label"]}]}`, `reserved output name "persons"`},
+		{`{"outputs": [{"name": "x", "classes": ["Q5"], "sources": ["label"]}], "persons": true}`, `persons: no output has bearers`},
+		{`{"outputs": [{"name": "x", "classes": ["Q5"], "sources": ["label"]}], "filters": [{"rule": "swearing", "action": "reject"}]}`, `unknown filter rule "swearing"`},
+		{`{"outputs": [{"name": "x", "classes": ["Q5"], "sources": ["label"]}], "filters": [{"rule": "url", "action": "repair"}]}`, `filter rule "url": cannot repair`},
+		{`{"outputs": [{"name": "x", "classes": ["Q5"], "sources": ["label"]}], "filters": [{"rule": "url", "action": "drop"}]}`, `filter rule "url": bad action "drop"`},
+		{`{"outputs": [{"name": "x", "classes": ["Q5"], "sources": ["label"]}], "filters": [
+			{"rule": "url", "action": "reject"},
+			{"rule": "url", "action": "reject"}
+		]}`, `duplicate filter rule "url"`},
+		{`{"outputs": [
+			{"name": "x", "classes": ["Q5"], "sources": ["label"]},
+			{"name": "x", "classes": ["Q6"], "sources": ["label"]}
+		]}`, `duplicate output "x"`},
+	} {
+		path := filepath.Join(dir, "config.json")
+		if err := os.WriteFile(path, []byte(tc.config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := ReadConfig(path)
+		if err == nil || !strings.HasSuffix(err.Error(), tc.want) {
+			t.Errorf("got %v, want %q", err, tc.want)
+		}
+	}
+}
+
+func TestOutputConfigClassIDs(t *testing.T) {
+	o := OutputConfig{Classes: []string{"Q5", "Q202444"}, Exclude: []string{"Q7"}}
+	if got := o.ClassIDs(); len(got) != 2 || got[0] != 5 || got[1] != 202444 {
+		t.Errorf("got ClassIDs() = %v", got)
+	}
+	if got := o.ExcludeIDs(); len(got) != 1 || got[0] != 7 {
+		t.Errorf("got ExcludeIDs() = %v", got)
+	}
+}
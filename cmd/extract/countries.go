@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// nameCountry is a name item and a country of citizenship (P27)
+// of its bearers, both as numeric Wikidata IDs.
+type nameCountry struct {
+	name    int64
+	country int64
+}
+
+// CountriesHeader is the CSV header of the name countries file.
+var CountriesHeader = []string{"WikidataID", "Country", "Bearers"}
+
+// countriesPath returns the path of the name countries file,
+// such as "name-countries-20230418.csv.gz" in the working directory.
+func countriesPath(workdir string, date time.Time) string {
+	return extractPath(workdir, "name-countries", date)
+}
+
+// WriteCountries writes how many bearers of each name are citizens
+// of each country into a gzipped CSV file, sorted by numeric name and
+// country ID. An item that is a name in several outputs, such as both
+// a given and a family name, gets the sum of its counts.
+func WriteCountries(path string, outputs []*Output) (int64, error) {
+	counts := make(map[nameCountry]int64, 100000)
+	for _, o := range outputs {
+		for k, n := range o.countries {
+			counts[k] += n
+		}
+	}
+	keys := make([]nameCountry, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b nameCountry) int {
+		return cmp.Or(cmp.Compare(a.name, b.name), cmp.Compare(a.country, b.country))
+	})
+
+	w, err := newCSVFileWriter(path, CountriesHeader)
+	if err != nil {
+		return 0, err
+	}
+	defer w.Abort()
+	for _, k := range keys {
+		err := w.Write([]string{
+			fmt.Sprintf("Q%d", k.name),
+			fmt.Sprintf("Q%d", k.country),
+			strconv.FormatInt(counts[k], 10),
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+	return int64(len(keys)), nil
+}
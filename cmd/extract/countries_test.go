@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteCountries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "name-countries-20230418.csv.gz")
+	outputs := []*Output{
+		{countries: map[nameCountry]int64{{7, 39}: 2, {1000, 34}: 1, {7, 34}: 5}},
+		{countries: map[nameCountry]int64{{7, 39}: 1, {80, 39}: 3}},
+	}
+	count, err := WriteCountries(path, outputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 4 {
+		t.Errorf("got count %d, want 4", count)
+	}
+
+	got, err := readExtract(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "WikidataID,Country,Bearers\n" +
+		"Q7,Q34,5\n" +
+		"Q7,Q39,3\n" +
+		"Q80,Q39,3\n" +
+		"Q1000,Q34,1\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestExtractorCountries(t *testing.T) {
+	workdir := t.TempDir()
+	m := runFullExtract(t, workdir, nil)
+
+	// Fictional humans and repeated countries do not count.
+	got, err := readExtract(countriesPath(workdir, fullDumpDate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "WikidataID,Country,Bearers\n" +
+		"Q127069,Q34,2\n" +
+		"Q127069,Q39,2\n" +
+		"Q145210,Q34,1\n" +
+		"Q145210,Q39,2\n" +
+		"Q167755,Q34,1\n" +
+		"Q167755,Q39,1\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if m.Countries != 6 || m.CountriesFile == nil || m.CountriesFile.Name != "name-countries-20230418.csv.gz" {
+		t.Errorf("got %d countries in %v", m.Countries, m.CountriesFile)
+	}
+}
@@ -0,0 +1,86 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"os"
+)
+
+// recordWriter is implemented by csv.Writer and csvFileWriter.
+type recordWriter interface {
+	Write(record []string) error
+}
+
+// csvFileWriter writes records into a gzipped CSV file, starting with
+// a header. The file gets written under a temporary name and renamed
+// into place by Close, so a crashed run never leaves a partial file.
+type csvFileWriter struct {
+	path       string
+	file       *os.File
+	compressor *gzip.Writer
+	writer     *csv.Writer
+	closed     bool
+}
+
+func newCSVFileWriter(path string, header []string) (*csvFileWriter, error) {
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	compressor, err := gzip.NewWriterLevel(file, 9)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	w := &csvFileWriter{
+		path:       path,
+		file:       file,
+		compressor: compressor,
+		writer:     csv.NewWriter(compressor),
+	}
+	if err := w.writer.Write(header); err != nil {
+		w.Abort()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *csvFileWriter) Write(record []string) error {
+	return w.writer.Write(record)
+}
+
+func (w *csvFileWriter) Close() error {
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		w.Abort()
+		return err
+	}
+	if err := w.compressor.Close(); err != nil {
+		w.Abort()
+		return err
+	}
+	w.closed = true
+	if err := w.file.Close(); err != nil {
+		os.Remove(w.file.Name())
+		return err
+	}
+	return os.Rename(w.file.Name(), w.path)
+}
+
+// Abort closes the csvFileWriter after an error, and removes its
+// temporary file. Once Close has been called, Abort does nothing,
+// so callers can defer it.
+func (w *csvFileWriter) Abort() {
+	if w.closed {
+		return
+	}
+	w.closed = true
+	w.file.Close()
+	os.Remove(w.file.Name())
+}
@@ -0,0 +1,52 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCSVFileWriter(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "name-countries-20230418.csv.gz")
+	w, err := newCSVFileWriter(path, CountriesHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write([]string{"Q1", "Q39", "7"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file should only appear after Close, got %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	w.Abort() // no-op after Close
+
+	got, err := readExtract(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "WikidataID,Country,Bearers\nQ1,Q39,7\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Errorf("got %d files, want 1", len(files))
+	}
+}
+
+func TestCSVFileWriterAbort(t *testing.T) {
+	dir := t.TempDir()
+	w, err := newCSVFileWriter(filepath.Join(dir, "foo.csv.gz"), []string{"A"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Abort()
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("got %d files after Abort, want none", len(files))
+	}
+}
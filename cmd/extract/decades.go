@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+// nameDecade is a name item and a decade in which some of its bearers
+// were born, such as 1920 for the years 1920 to 1929.
+type nameDecade struct {
+	name   int64
+	decade int64
+}
+
+// birthDecade returns the decade in which a human was born, taken
+// from the date of birth (P569). Dates less precise than a year are
+// ignored. If the remaining dates disagree on the decade, such as
+// when historians dispute a birth date, the decade is unknown.
+func birthDecade(e *mediawiki.Entity) (int64, bool) {
+	var decade int64
+	found := false
+	for _, v := range ClaimValues(e, "P569") {
+		t, ok := v.(mediawiki.TimeValue)
+		if !ok || t.Precision < mediawiki.Year {
+			continue
+		}
+		// Go numbers years astronomically, so 1 BCE is year 0,
+		// and 2 BCE falls into the decade from -10 to -1.
+		year := int64(t.Time.Year())
+		d := year - (year%10+10)%10
+		if found && d != decade {
+			return 0, false
+		}
+		decade, found = d, true
+	}
+	return decade, found
+}
+
+// DecadesHeader is the CSV header of the name decades file.
+var DecadesHeader = []string{"WikidataID", "Decade", "Bearers"}
+
+// decadesPath returns the path of the name decades file,
+// such as "name-decades-20230418.csv.gz" in the working directory.
+func decadesPath(workdir string, date time.Time) string {
+	return extractPath(workdir, "name-decades", date)
+}
+
+// WriteDecades writes how many bearers of each name were born in each
+// decade into a gzipped CSV file, sorted by numeric name ID and decade.
+// Like in WriteCountries, an item that is a name in several outputs
+// gets the sum of its counts.
+func WriteDecades(path string, outputs []*Output) (int64, error) {
+	counts := make(map[nameDecade]int64, 100000)
+	for _, o := range outputs {
+		for k, n := range o.decades {
+			counts[k] += n
+		}
+	}
+	keys := make([]nameDecade, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b nameDecade) int {
+		return cmp.Or(cmp.Compare(a.name, b.name), cmp.Compare(a.decade, b.decade))
+	})
+
+	w, err := newCSVFileWriter(path, DecadesHeader)
+	if err != nil {
+		return 0, err
+	}
+	defer w.Abort()
+	for _, k := range keys {
+		err := w.Write([]string{
+			fmt.Sprintf("Q%d", k.name),
+			strconv.FormatInt(k.decade, 10),
+			strconv.FormatInt(counts[k], 10),
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+	return int64(len(keys)), nil
+}
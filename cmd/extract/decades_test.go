@@ -0,0 +1,97 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+func TestBirthDecade(t *testing.T) {
+	date := func(year int, precision mediawiki.TimePrecision) mediawiki.TimeValue {
+		return mediawiki.TimeValue{
+			Time:      time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC),
+			Precision: precision,
+		}
+	}
+	for _, tc := range []struct {
+		dates      []mediawiki.TimeValue
+		want       int64
+		wantExists bool
+	}{
+		{nil, 0, false},
+		{[]mediawiki.TimeValue{date(1921, mediawiki.Day)}, 1920, true},
+		{[]mediawiki.TimeValue{date(2000, mediawiki.Year)}, 2000, true},
+		{[]mediawiki.TimeValue{date(1929, mediawiki.Month)}, 1920, true},
+		{[]mediawiki.TimeValue{date(1920, mediawiki.Decade)}, 0, false},
+		{[]mediawiki.TimeValue{date(-1, mediawiki.Year)}, -10, true},
+		{[]mediawiki.TimeValue{date(1921, mediawiki.Day), date(1923, mediawiki.Year)}, 1920, true},
+		{[]mediawiki.TimeValue{date(1929, mediawiki.Year), date(1930, mediawiki.Year)}, 0, false},
+		{[]mediawiki.TimeValue{date(1850, mediawiki.Century), date(1854, mediawiki.Day)}, 1850, true},
+	} {
+		var statements []mediawiki.Statement
+		for _, d := range tc.dates {
+			statements = append(statements, claim(d))
+		}
+		e := mediawiki.Entity{Claims: map[string][]mediawiki.Statement{"P569": statements}}
+		got, exists := birthDecade(&e)
+		if got != tc.want || exists != tc.wantExists {
+			t.Errorf("%v: got %d %v, want %d %v", tc.dates, got, exists, tc.want, tc.wantExists)
+		}
+	}
+}
+
+func TestWriteDecades(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "name-decades-20230418.csv.gz")
+	outputs := []*Output{
+		{decades: map[nameDecade]int64{{7, 1990}: 2, {1000, 1920}: 1, {7, -10}: 1}},
+		{decades: map[nameDecade]int64{{7, 1990}: 1, {80, 2010}: 3}},
+	}
+	count, err := WriteDecades(path, outputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 4 {
+		t.Errorf("got count %d, want 4", count)
+	}
+
+	got, err := readExtract(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "WikidataID,Decade,Bearers\n" +
+		"Q7,-10,1\n" +
+		"Q7,1990,3\n" +
+		"Q80,2010,3\n" +
+		"Q1000,1920,1\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestExtractorDecades(t *testing.T) {
+	workdir := t.TempDir()
+	m := runFullExtract(t, workdir, nil)
+
+	// Birth dates that are only known to the decade do not count.
+	got, err := readExtract(decadesPath(workdir, fullDumpDate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "WikidataID,Decade,Bearers\n" +
+		"Q127069,1920,1\n" +
+		"Q127069,2000,1\n" +
+		"Q145210,1920,1\n" +
+		"Q145210,2000,1\n" +
+		"Q167755,2000,1\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if m.Decades != 5 || m.DecadesFile == nil || m.DecadesFile.Name != "name-decades-20230418.csv.gz" {
+		t.Errorf("got %d decades in %v", m.Decades, m.DecadesFile)
+	}
+}
@@ -0,0 +1,233 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// errIncompatible tells that an extract cannot be compared, such as
+// when an older version of the extractor has written other columns or
+// sorted the names in another order.
+var errIncompatible = errors.New("incompatible extract")
+
+// changesPath returns the path of a file that lists the changes
+// of an extract, such as "givennames-added-20230418.csv.gz".
+func changesPath(workdir string, name string, kind string, date time.Time) string {
+	day := date.Format("20060102")
+	return filepath.Join(workdir, fmt.Sprintf("%s-%s-%s.csv.gz", name, kind, day))
+}
+
+// DiffExtracts compares two extracts and writes the names that have
+// been added to newPath and the names that have been removed from
+// oldPath. A name is identified by its spelling and Wikidata ID;
+// if only other columns changed, such as the languages, it does not
+// count as a change. Because both extracts are sorted, we can find
+// the differences in a single pass over both files.
+func DiffExtracts(oldPath, newPath, addedPath, removedPath string) (added int64, removed int64, err error) {
+	oldReader, err := openExtractReader(oldPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer oldReader.Close()
+
+	newReader, err := openExtractReader(newPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer newReader.Close()
+
+	addedWriter, err := newCSVFileWriter(addedPath, NameHeader)
+	if err != nil {
+		return 0, 0, err
+	}
+	removedWriter, err := newCSVFileWriter(removedPath, NameHeader)
+	if err != nil {
+		addedWriter.Abort()
+		return 0, 0, err
+	}
+
+	abort := func(err error) (int64, int64, error) {
+		addedWriter.Abort()
+		removedWriter.Abort()
+		return 0, 0, err
+	}
+
+	oldGroup, err := oldReader.ReadGroup()
+	if err != nil {
+		return abort(err)
+	}
+	newGroup, err := newReader.ReadGroup()
+	if err != nil {
+		return abort(err)
+	}
+	for len(oldGroup) > 0 || len(newGroup) > 0 {
+		var c int
+		if len(oldGroup) == 0 {
+			c = 1
+		} else if len(newGroup) == 0 {
+			c = -1
+		} else {
+			c = compareNameKeys(&oldGroup[0], &newGroup[0])
+		}
+
+		if c < 0 {
+			if err := writeNames(removedWriter, oldGroup); err != nil {
+				return abort(err)
+			}
+			removed += int64(len(oldGroup))
+		} else if c > 0 {
+			if err := writeNames(addedWriter, newGroup); err != nil {
+				return abort(err)
+			}
+			added += int64(len(newGroup))
+		}
+
+		if c <= 0 {
+			if oldGroup, err = oldReader.ReadGroup(); err != nil {
+				return abort(err)
+			}
+		}
+		if c >= 0 {
+			if newGroup, err = newReader.ReadGroup(); err != nil {
+				return abort(err)
+			}
+		}
+	}
+
+	if err := addedWriter.Close(); err != nil {
+		removedWriter.Abort()
+		return 0, 0, err
+	}
+	if err := removedWriter.Close(); err != nil {
+		return 0, 0, err
+	}
+	return added, removed, nil
+}
+
+// extractReader reads the names of a gzipped CSV extract, checking
+// that they come in sorted order.
+type extractReader struct {
+	path         string
+	file         *os.File
+	decompressor *gzip.Reader
+	reader       *csv.Reader
+	next         *Name
+	last         *Name
+}
+
+func openExtractReader(path string) (*extractReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	decompressor, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	r := &extractReader{
+		path:         path,
+		file:         file,
+		decompressor: decompressor,
+		reader:       csv.NewReader(decompressor),
+	}
+
+	header, err := r.reader.Read()
+	if err != nil {
+		r.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if strings.Join(header, ",") != strings.Join(NameHeader, ",") {
+		r.Close()
+		return nil, fmt.Errorf("%s: %w: unexpected header %q", path, errIncompatible, header)
+	}
+
+	return r, nil
+}
+
+// ReadGroup returns the next rows that have the same spelling and
+// Wikidata ID, or an empty slice at the end of the extract.
+func (r *extractReader) ReadGroup() ([]Name, error) {
+	var group []Name
+	for {
+		if r.next == nil {
+			n, err := r.read()
+			if err != nil {
+				return nil, err
+			} else if n == nil {
+				return group, nil
+			}
+			r.next = n
+		}
+		if len(group) > 0 && compareNameKeys(&group[0], r.next) != 0 {
+			return group, nil
+		}
+		group = append(group, *r.next)
+		r.next = nil
+	}
+}
+
+func (r *extractReader) read() (*Name, error) {
+	record, err := r.reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("%s: %v", r.path, err)
+	}
+
+	n, err := NameFromRecord(record)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", r.path, err)
+	}
+	if r.last != nil && compareNames(r.last, &n) > 0 {
+		return nil, fmt.Errorf("%s: %w: not sorted", r.path, errIncompatible)
+	}
+	r.last = &n
+	return &n, nil
+}
+
+func (r *extractReader) Close() error {
+	r.decompressor.Close()
+	return r.file.Close()
+}
+
+// writeNames writes names, which must already be sorted,
+// into the file of added or removed names.
+func writeNames(w *csvFileWriter, names []Name) error {
+	for _, n := range names {
+		if err := w.Write(n.Record()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diff compares the extract that Close has written to the extract
+// of an earlier date, and records the changes for the manifest.
+func (o *Output) diff(previous time.Time) error {
+	added := changesPath(o.workdir, o.name, "added", o.date)
+	removed := changesPath(o.workdir, o.name, "removed", o.date)
+	numAdded, numRemoved, err := DiffExtracts(
+		extractPath(o.workdir, o.name, previous), extractPath(o.workdir, o.name, o.date),
+		added, removed)
+	if err != nil {
+		return err
+	}
+
+	o.previous = previous
+	o.added, o.removed = numAdded, numRemoved
+	o.files = append(o.files, added, removed)
+	return nil
+}
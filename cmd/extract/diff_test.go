@@ -0,0 +1,147 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiffExtracts(t *testing.T) {
+	dir := t.TempDir()
+	oldPath := filepath.Join(dir, "old.csv.gz")
+	newPath := filepath.Join(dir, "new.csv.gz")
+	writeExtract(t, oldPath,
+		"Anna,Q1,de,alias,Latn,,,anna,0\n"+
+			"Anna,Q1,de,label,Latn,,,anna,0\n"+
+			"Bob,Q2,en,label,Latn,,,bob,0\n"+
+			"Carl,Q3,en,label,Latn,,,carl,0\n")
+	writeExtract(t, newPath,
+		"Anna,Q1,de;en,label,Latn,,,anna,0\n"+
+			"Bob,Q2,en,label,Latn,,,bob,0\n"+
+			"Bob,Q20,en,label,Latn,,,bob,0\n"+
+			"Dora,Q4,sv,alias,Latn,,,dora,0\n"+
+			"Dora,Q4,en,label,Latn,,,dora,0\n")
+
+	addedPath := filepath.Join(dir, "added.csv.gz")
+	removedPath := filepath.Join(dir, "removed.csv.gz")
+	added, removed, err := DiffExtracts(oldPath, newPath, addedPath, removedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 3 || removed != 1 {
+		t.Errorf("got %d added, %d removed; want 3, 1", added, removed)
+	}
+
+	// Changed languages do not count as a change of the name.
+	wantAdded := strings.Join(NameHeader, ",") + "\n" +
+		"Bob,Q20,en,label,Latn,,,bob,0\n" +
+		"Dora,Q4,sv,alias,Latn,,,dora,0\n" +
+		"Dora,Q4,en,label,Latn,,,dora,0\n"
+	if got, err := readExtract(addedPath); err != nil {
+		t.Fatal(err)
+	} else if got != wantAdded {
+		t.Errorf("got added %q, want %q", got, wantAdded)
+	}
+
+	wantRemoved := strings.Join(NameHeader, ",") + "\n" +
+		"Carl,Q3,en,label,Latn,,,carl,0\n"
+	if got, err := readExtract(removedPath); err != nil {
+		t.Fatal(err)
+	} else if got != wantRemoved {
+		t.Errorf("got removed %q, want %q", got, wantRemoved)
+	}
+}
+
+func TestDiffExtractsIncompatible(t *testing.T) {
+	dir := t.TempDir()
+	oldPath := filepath.Join(dir, "old.csv.gz")
+	newPath := filepath.Join(dir, "new.csv.gz")
+
+	// Q1000 comes after Q200 in numeric order.
+	writeExtract(t, oldPath, "Anna,Q1000,,label,,,,anna,0\nAnna,Q200,,label,,,,anna,0\n")
+	writeExtract(t, newPath, "Anna,Q200,,label,,,,anna,0\n")
+
+	addedPath := filepath.Join(dir, "added.csv.gz")
+	removedPath := filepath.Join(dir, "removed.csv.gz")
+	_, _, err := DiffExtracts(oldPath, newPath, addedPath, removedPath)
+	if !errors.Is(err, errIncompatible) {
+		t.Errorf("got %v, want errIncompatible", err)
+	}
+
+	// Extracts by older versions of the extractor had fewer columns.
+	oldHeader := "Name,WikidataID,Languages,Source,Scripts,WritingSystems,Classes\n"
+	writeGzip(t, oldPath, oldHeader+"Anna,Q200,,label,,,\n")
+	_, _, err = DiffExtracts(oldPath, newPath, addedPath, removedPath)
+	if !errors.Is(err, errIncompatible) {
+		t.Errorf("got %v, want errIncompatible", err)
+	}
+	for _, path := range []string{addedPath, removedPath, addedPath + ".tmp", removedPath + ".tmp"} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("expected %s to not exist, got %v", path, err)
+		}
+	}
+}
+
+func TestExtractorChanges(t *testing.T) {
+	workdir := t.TempDir()
+
+	// The previous extract had one name less for Q145210,
+	// and one name that is not in the current dump.
+	for _, f := range []string{"familynames", "givennames"} {
+		want, err := os.ReadFile(filepath.Join("testdata", "full", "want_"+f+".csv"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		previous := strings.Replace(string(want), "Weisz,Q145210,sv,alias,Latn,Q8229,Q98775491,weisz,2\n", "", 1)
+		previous = "Aaron,Q1,en,label,Latn,,,aaron,0\n" + strings.TrimPrefix(previous, strings.Join(NameHeader, ",")+"\n")
+		writeExtract(t, filepath.Join(workdir, f+"-20230411.csv.gz"), previous)
+	}
+
+	m := runFullExtract(t, workdir, nil)
+	for _, o := range m.Outputs {
+		wantAdded := int64(0)
+		if o.Name == "familynames" {
+			wantAdded = 1
+		}
+		if o.PreviousDate != "2023-04-11" || o.Added != wantAdded || o.Removed != 1 {
+			t.Errorf("%s: got previous=%q added=%d removed=%d, want 2023-04-11 %d 1",
+				o.Name, o.PreviousDate, o.Added, o.Removed, wantAdded)
+		}
+	}
+
+	got, err := readExtract(filepath.Join(workdir, "familynames-added-20230418.csv.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join(NameHeader, ",") + "\n" +
+		"Weisz,Q145210,sv,alias,Latn,Q8229,Q98775491,weisz,2\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// writeExtract writes a gzipped CSV extract with the given rows.
+func writeExtract(t *testing.T, path string, rows string) {
+	writeGzip(t, path, strings.Join(NameHeader, ",")+"\n"+rows)
+}
+
+func writeGzip(t *testing.T, path string, content string) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	compressor := gzip.NewWriter(file)
+	if _, err := compressor.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := compressor.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
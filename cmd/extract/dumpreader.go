@@ -0,0 +1,364 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cosnicolaou/pbzip2"
+	"gitlab.com/tozd/go/x"
+)
+
+// DumpPosition tells where an interrupted run can resume reading
+// a bzip2-compressed dump. Offset is the position, in bits from
+// the start of the file, of the magic number that starts a compressed
+// block. Level is the block size of its bzip2 stream, from 1 to 9,
+// and StreamCRC is the combined checksum of the stream's earlier
+// blocks. Skip is the number of decompressed bytes from the start
+// of the block to the position.
+type DumpPosition struct {
+	Offset    int64  `json:"offset"`
+	Level     int    `json:"level"`
+	StreamCRC uint32 `json:"stream_crc"`
+	Skip      int64  `json:"skip"`
+}
+
+// The magic number at the start of every compressed bzip2 block.
+var blockMagic = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+
+// dumpBlock is a compressed block of a dump, which starts at
+// offset bits into the file.
+type dumpBlock struct {
+	offset    int64
+	level     int
+	streamCRC uint32 // of the stream's blocks before this one
+}
+
+// blockStart tells where a compressed block of a dump begins in the
+// decompressed data.
+type blockStart struct {
+	block dumpBlock
+	start int64
+}
+
+// dumpReader reads the entities of a bzip2-compressed JSON dump.
+// Like mediawiki.Process, it decompresses blocks in parallel, but it
+// also keeps track of where the blocks start. This allows Position
+// to tell where an interrupted run can resume, and openDump to seek
+// there, so a resumed run does not need to decompress the entities
+// that it has already processed.
+type dumpReader struct {
+	file   *os.File
+	input  *x.CountingReader
+	ticker *x.Ticker
+	dc     *pbzip2.Decompressor
+	cancel context.CancelFunc
+	pos    *DumpPosition // where reading started, or nil
+	dec    *json.Decoder
+	base   int64 // decompressed offset of dec's input
+
+	scanned  chan error
+	scanErr  error
+	scanDone bool
+
+	// The decompressor reports each block after its data has been
+	// read, and before it passes on the data of the next block.
+	// Because Read drains the reports, a few slots are plenty.
+	updates chan pbzip2.Progress
+	starts  []blockStart
+	end     int64 // decompressed size of the reported blocks
+
+	mu       sync.Mutex
+	appended map[uint64]dumpBlock // by decompressor order
+}
+
+// openDump starts decompressing a dump with the given number of
+// threads. If pos is not nil, decompression starts at that position
+// instead of the start of the file. Progress gets called regularly
+// with the number of compressed bytes read; when resuming, this
+// only covers the part of the dump after pos.
+func openDump(ctx context.Context, path string, pos *DumpPosition, threads int, progress func(x.Progress)) (*dumpReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// When resuming, we feed the scanner a stream header, followed by
+	// the file from the byte that contains the magic number of the
+	// block. Because we seek to a byte boundary, any later streams
+	// remain aligned to bytes, as the scanner expects.
+	first := dumpBlock{offset: 32}
+	var header io.Reader = strings.NewReader("")
+	if pos != nil {
+		first = dumpBlock{pos.Offset, pos.Level, pos.StreamCRC}
+		if err := checkBlockMagic(file, pos.Offset); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if _, err := file.Seek(pos.Offset/8, io.SeekStart); err != nil {
+			file.Close()
+			return nil, err
+		}
+		header = strings.NewReader(fmt.Sprintf("BZh%d", pos.Level))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	r := &dumpReader{
+		file:     file,
+		input:    x.NewCountingReader(file),
+		cancel:   cancel,
+		pos:      pos,
+		scanned:  make(chan error, 1),
+		updates:  make(chan pbzip2.Progress, 16),
+		appended: make(map[uint64]dumpBlock, threads*4),
+	}
+	size := stat.Size()
+	if pos != nil {
+		size -= pos.Offset / 8
+	}
+	r.ticker = x.NewTicker(ctx, r.input, size, 30*time.Second)
+	go func() {
+		for p := range r.ticker.C {
+			if progress != nil {
+				progress(p)
+			}
+		}
+	}()
+
+	r.dc = pbzip2.NewDecompressor(ctx, pbzip2.BZConcurrency(threads), pbzip2.BZSendUpdates(r.updates))
+	sc := pbzip2.NewScanner(io.MultiReader(header, r.input))
+	go func() {
+		err := r.scan(ctx, sc, first, pos != nil)
+		if err != nil {
+			r.dc.Cancel(err)
+		}
+		if ferr := r.dc.Finish(); err == nil {
+			err = ferr
+		}
+		r.scanned <- err
+	}()
+	return r, nil
+}
+
+// checkBlockMagic returns an error unless the magic number of
+// a compressed block starts at offset bits into file.
+func checkBlockMagic(file *os.File, offset int64) error {
+	buf := make([]byte, len(blockMagic)+1)
+	if _, err := file.ReadAt(buf, offset/8); err != nil && err != io.EOF {
+		return err
+	}
+	shift := offset % 8
+	for i := range blockMagic {
+		if buf[i]<<shift|buf[i+1]>>(8-shift) != blockMagic[i] {
+			return fmt.Errorf("no compressed block at bit %d", offset)
+		}
+	}
+	return nil
+}
+
+// scan finds the compressed blocks of the dump and hands them to the
+// decompressor. It keeps track of the offset of each block, starting
+// with the first. When resuming, the blocks of the first stream start
+// with first.streamCRC, but the decompressor only gets to see the
+// remaining ones, so we check the stream checksum ourselves.
+func (r *dumpReader) scan(ctx context.Context, sc *pbzip2.Scanner, first dumpBlock, resumed bool) error {
+	block := first
+	streamCRC := first.streamCRC
+	var order uint64
+	var partialCRC uint32
+	for sc.Scan(ctx) {
+		b := sc.Block()
+		if resumed && order == 0 && block.offset%8 != 0 {
+			// The bits before the magic number, in the byte
+			// that we seeked to, look like a block of their own.
+			resumed = false
+			continue
+		}
+		if b.StreamBlockSize > 0 {
+			block.level = b.StreamBlockSize / (100 * 1000)
+		}
+		block.streamCRC = streamCRC
+		streamCRC = updateStreamCRC(streamCRC, b.CRC)
+		partialCRC = updateStreamCRC(partialCRC, b.CRC)
+		if b.EOS {
+			if streamCRC != b.StreamCRC {
+				return fmt.Errorf("mismatched stream checksums: calculated=0x%08x, stored=0x%08x",
+					streamCRC, b.StreamCRC)
+			}
+			b.StreamCRC = partialCRC
+			streamCRC, partialCRC = 0, 0
+		}
+
+		order++
+		r.mu.Lock()
+		r.appended[order] = block
+		r.mu.Unlock()
+		if err := r.dc.Append(b); err != nil {
+			return err
+		}
+
+		// The next block starts right after this one, unless this
+		// one ends its stream. In that case, the stream trailer of
+		// 80 bits gets padded to a full byte, followed by the 32-bit
+		// header of the next stream.
+		next := block.offset + int64(len(blockMagic)*8+b.SizeInBits)
+		if b.EOS {
+			next = (next+80+7)/8*8 + 32
+		}
+		block.offset = next
+	}
+	return sc.Err()
+}
+
+// updateStreamCRC combines the checksum of a bzip2 stream with that
+// of its next block, like the bzip2 compressor does.
+func updateStreamCRC(streamCRC, blockCRC uint32) uint32 {
+	return (streamCRC<<1 | streamCRC>>31) ^ blockCRC
+}
+
+func (r *dumpReader) Read(buf []byte) (int, error) {
+	n, err := r.dc.Read(buf)
+	if n > 0 {
+		r.decompressed()
+	}
+	if err == io.EOF {
+		if scanErr := r.waitScan(); scanErr != nil {
+			return n, scanErr
+		}
+	}
+	return n, err
+}
+
+// decompressed records where the blocks begin whose data has been
+// read so far. The decompressor reports blocks in order, but if it had
+// to merge a block with the next one, the latter's start stays unknown.
+func (r *dumpReader) decompressed() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.end == 0 && len(r.starts) == 0 {
+		r.startBlock(1)
+	}
+	for {
+		select {
+		case u := <-r.updates:
+			r.end += int64(u.Size)
+			r.startBlock(u.Block + 1)
+		default:
+			return
+		}
+	}
+}
+
+func (r *dumpReader) startBlock(order uint64) {
+	if b, ok := r.appended[order]; ok {
+		delete(r.appended, order)
+		r.starts = append(r.starts, blockStart{b, r.end})
+	}
+}
+
+func (r *dumpReader) waitScan() error {
+	if !r.scanDone {
+		r.scanErr = <-r.scanned
+		r.scanDone = true
+	}
+	return r.scanErr
+}
+
+// Entities calls fn for every entity in the dump, in dump order.
+// When resuming, this starts with the entity after the position.
+func (r *dumpReader) Entities(fn func(raw rawEntity) error) error {
+	br := bufio.NewReaderSize(r, 1<<20)
+	var input io.Reader = br
+	if r.pos != nil {
+		// Discard the part of the dump that is already processed,
+		// together with the comma after the last entity there.
+		// To let the JSON decoder continue with the array, we give it
+		// an opening bracket instead.
+		if _, err := io.CopyN(io.Discard, br, r.pos.Skip); err != nil {
+			return err
+		}
+		r.base = r.pos.Skip
+		for {
+			c, err := br.ReadByte()
+			if err != nil {
+				return err
+			}
+			r.base++
+			if c == ',' {
+				break
+			}
+			if !strings.ContainsRune(" \t\r\n", rune(c)) {
+				br.UnreadByte()
+				r.base--
+				break
+			}
+		}
+		input = io.MultiReader(strings.NewReader("["), br)
+		r.base--
+	}
+
+	r.dec = json.NewDecoder(input)
+	if tok, err := r.dec.Token(); err != nil {
+		return err
+	} else if tok != json.Delim('[') {
+		return fmt.Errorf("expected JSON array, got %v", tok)
+	}
+	for r.dec.More() {
+		var raw rawEntity
+		if err := r.dec.Decode(&raw); err != nil {
+			return err
+		}
+		if err := fn(raw); err != nil {
+			return err
+		}
+	}
+	_, err := r.dec.Token()
+	return err
+}
+
+// Position tells where to resume reading after the last entity that
+// Entities has passed to its callback. The result is nil if this
+// is not known yet.
+func (r *dumpReader) Position() *DumpPosition {
+	offset := r.base + r.dec.InputOffset()
+	i := len(r.starts) - 1
+	for i >= 0 && r.starts[i].start > offset {
+		i--
+	}
+	if i < 0 {
+		return nil
+	}
+
+	// Later calls cannot go back to earlier blocks.
+	r.starts = r.starts[i:]
+	s := r.starts[0]
+	return &DumpPosition{
+		Offset:    s.block.offset,
+		Level:     s.block.level,
+		StreamCRC: s.block.streamCRC,
+		Skip:      offset - s.start,
+	}
+}
+
+// Close stops decompressing the dump, and releases its resources.
+func (r *dumpReader) Close() error {
+	r.cancel()
+	r.dc.Cancel(context.Canceled)
+	r.waitScan()
+	r.ticker.Stop()
+	return r.file.Close()
+}
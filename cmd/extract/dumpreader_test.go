@@ -0,0 +1,122 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// The dump for this test has 4000 entities in two bzip2 streams,
+// with three compressed blocks in the second stream. The streams
+// split the dump in the middle of an entity. Most blocks do not
+// start at a byte boundary.
+const multistreamDump = "testdata/multistream/entities.json.bz2"
+
+// Offsets of the compressed blocks in multistreamDump, in bits from
+// the start of the file. The second stream starts at bit 57096.
+var multistreamBlocks = []int64{32, 41269, 57096, 98638, 140426}
+
+func TestDumpReader(t *testing.T) {
+	path := filepath.FromSlash(multistreamDump)
+	entities, positions := readDump(t, path, nil)
+	if len(entities) != 4000 {
+		t.Fatalf("got %d entities, want 4000", len(entities))
+	}
+
+	blocks := make(map[int64]bool)
+	for i, pos := range positions {
+		if pos == nil {
+			continue
+		}
+		if !slices.Contains(multistreamBlocks, pos.Offset) {
+			t.Errorf("position after entity %d has offset %d, which is not a block start",
+				i, pos.Offset)
+		}
+
+		// Resume at the first position in every block, and
+		// at some more positions in between.
+		first := !blocks[pos.Offset]
+		blocks[pos.Offset] = true
+		if !first && i%97 != 0 && i != len(positions)-1 {
+			continue
+		}
+		rest, _ := readDump(t, path, pos)
+		if !slices.EqualFunc(rest, entities[i+1:], func(a, b rawEntity) bool {
+			return string(a) == string(b)
+		}) {
+			t.Errorf("resuming at %+v after entity %d: got %d entities, want %d",
+				pos, i, len(rest), len(entities)-i-1)
+		}
+	}
+
+	var unaligned, secondStream bool
+	for offset := range blocks {
+		unaligned = unaligned || offset%8 != 0
+		secondStream = secondStream || offset >= multistreamBlocks[2]
+	}
+	if len(blocks) < 4 {
+		t.Errorf("got positions in %d blocks, want at least 4", len(blocks))
+	}
+	if !unaligned {
+		t.Error("got no positions in blocks that start within a byte")
+	}
+	if !secondStream {
+		t.Error("got no positions in the second stream")
+	}
+}
+
+func TestCheckBlockMagic(t *testing.T) {
+	file, err := os.Open(filepath.FromSlash(multistreamDump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	for _, offset := range multistreamBlocks {
+		if err := checkBlockMagic(file, offset); err != nil {
+			t.Errorf("offset %d: %v", offset, err)
+		}
+		for _, bad := range []int64{offset - 1, offset + 1, offset + 8} {
+			if err := checkBlockMagic(file, bad); err == nil {
+				t.Errorf("offset %d: expected error", bad)
+			}
+		}
+	}
+}
+
+func TestDumpReaderBadPosition(t *testing.T) {
+	path := filepath.FromSlash(multistreamDump)
+	pos := &DumpPosition{Offset: 33, Level: 1}
+	if r, err := openDump(context.Background(), path, pos, 2, nil); err == nil {
+		r.Close()
+		t.Error("expected error for position without compressed block")
+	}
+}
+
+// readDump returns the entities of a dump, starting at a position,
+// together with the position after each entity.
+func readDump(t *testing.T, path string, pos *DumpPosition) ([]rawEntity, []*DumpPosition) {
+	t.Helper()
+	r, err := openDump(context.Background(), path, pos, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	var entities []rawEntity
+	var positions []*DumpPosition
+	err = r.Entities(func(raw rawEntity) error {
+		entities = append(entities, raw)
+		positions = append(positions, r.Position())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entities, positions
+}
@@ -4,11 +4,6 @@
 package main
 
 import (
-	"encoding/csv"
-	"fmt"
-	"io"
-	"net/http"
-	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -53,74 +48,80 @@ func (self *ClassSet) ContainsAny(other *ClassSet) bool {
 
 func WikidataClasses(e *mediawiki.Entity) ClassSet {
 	result := make(ClassSet, 3)
-	for prop, claims := range e.Claims {
-		if prop == "P31" {
-			for _, claim := range claims {
-				snak := claim.MainSnak
-				if snak.SnakType == mediawiki.Value {
-					if val, ok := snak.DataValue.Value.(mediawiki.WikiBaseEntityIDValue); ok {
-						if qid, err := strconv.ParseInt(val.ID[1:], 10, 64); err == nil {
-							result[qid] = struct{}{}
-						}
-					}
-				}
-			}
-		}
+	for _, qid := range ItemClaims(e, "P31") {
+		result[qid] = struct{}{}
 	}
 	return result
 }
 
-func QuerySubclasses(classID int64, client *http.Client) (ClassSet, error) {
-	query := fmt.Sprintf(
-		"SELECT ?subclass WHERE {?subclass wdt:P279* wd:Q%d. }",
-		classID)
-	queryUrl := ("https://query.wikidata.org/sparql?query=" +
-		url.QueryEscape(query))
-
-	req, err := http.NewRequest("GET", queryUrl, nil)
-	if err != nil {
-		return nil, err
+// BestClaims returns an entity's claims for a property that are
+// best by rank, like the "truthy" statements of the Wikidata Query
+// Service: if any claim has preferred rank, the preferred claims;
+// otherwise all claims of normal rank. Deprecated claims, such as
+// a wrong date of birth that was once widely cited, never count.
+func BestClaims(e *mediawiki.Entity, prop string) []mediawiki.Statement {
+	claims := e.Claims[prop]
+	rank := mediawiki.Normal
+	for _, claim := range claims {
+		if claim.Rank == mediawiki.Preferred {
+			rank = mediawiki.Preferred
+			break
+		}
 	}
-
-	req.Header.Add("Accept", "text/csv")
-	req.Header.Add("User-Agent", "WikidataNamesBot/1.0")
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+	result := make([]mediawiki.Statement, 0, len(claims))
+	for _, claim := range claims {
+		if claim.Rank == rank {
+			result = append(result, claim)
+		}
 	}
-	defer resp.Body.Close()
+	return result
+}
 
-	cset := make(ClassSet, 500)
-	cset[classID] = struct{}{}
-	reader := csv.NewReader(resp.Body)
-	for {
-		record, err := reader.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, err
+// ClaimValues returns the values of an entity's best claims for a
+// property, such as mediawiki.WikiBaseEntityIDValue or
+// mediawiki.StringValue. Claims without a value, or whose value
+// is unknown, are skipped.
+func ClaimValues(e *mediawiki.Entity, prop string) []interface{} {
+	claims := BestClaims(e, prop)
+	result := make([]interface{}, 0, len(claims))
+	for _, claim := range claims {
+		snak := claim.MainSnak
+		if snak.SnakType == mediawiki.Value && snak.DataValue != nil {
+			result = append(result, snak.DataValue.Value)
 		}
-		const prefix = "http://www.wikidata.org/entity/Q"
-		if len(record) == 1 && strings.HasPrefix(record[0], prefix) {
-			val, err := strconv.ParseInt(record[0][len(prefix):], 10, 64)
-			if err == nil {
-				cset[val] = struct{}{}
+	}
+	return result
+}
+
+// ItemClaims returns the numeric IDs of the items that an entity's
+// best claims for a property point to, such as 5 for "Q5".
+func ItemClaims(e *mediawiki.Entity, prop string) []int64 {
+	values := ClaimValues(e, prop)
+	result := make([]int64, 0, len(values))
+	for _, v := range values {
+		if val, ok := v.(mediawiki.WikiBaseEntityIDValue); ok {
+			if qid, err := strconv.ParseInt(val.ID[1:], 10, 64); err == nil {
+				result = append(result, qid)
 			}
 		}
 	}
+	return result
+}
 
-	return cset, nil
+// Subclasser finds all transitive subclasses of a Wikidata class.
+// The returned set includes the class itself.
+type Subclasser interface {
+	Subclasses(classID int64) (ClassSet, error)
 }
 
 func extractNames(path string, w *NameWriter) error {
-	if err := w.WriteName(&Name{"Qux", "Q789"}); err != nil {
+	if err := w.WriteName(&Name{Name: "Qux", ID: "Q789"}); err != nil {
 		return err
 	}
-	if err := w.WriteName(&Name{"Foo", "Q456"}); err != nil {
+	if err := w.WriteName(&Name{Name: "Foo", ID: "Q456"}); err != nil {
 		return err
 	}
-	if err := w.WriteName(&Name{"Bar", "Q123"}); err != nil {
+	if err := w.WriteName(&Name{Name: "Bar", ID: "Q123"}); err != nil {
 		return err
 	}
 	return nil
@@ -4,15 +4,13 @@
 package main
 
 import (
-	"bytes"
-	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"path/filepath"
-	"sort"
-	"strings"
+	"reflect"
 	"testing"
+
+	"gitlab.com/tozd/go/mediawiki"
 )
 
 func TestFindEntitiesDump(t *testing.T) {
@@ -68,37 +66,6 @@ func NewTestClient(fn RoundTripFunc) *http.Client {
 	}
 }
 
-func TestQuerySubclasses(t *testing.T) {
-	client := NewTestClient(func(req *http.Request) *http.Response {
-		var buf bytes.Buffer
-		buf.WriteString("class\n")
-		buf.WriteString("http://www.wikidata.org/entity/Q123\n")
-		buf.WriteString("http://www.wikidata.org/entity/Q987\n")
-		return &http.Response{
-			StatusCode: 200,
-			Header:     make(http.Header),
-			Body:       io.NopCloser(&buf),
-		}
-	})
-
-	gotSet, err := QuerySubclasses(777, client)
-	if err != nil {
-		t.Error(err)
-		return
-	}
-	gotVec := make([]string, 0, len(gotSet))
-	for qid, _ := range gotSet {
-		gotVec = append(gotVec, fmt.Sprintf("Q%d", qid))
-	}
-	sort.Strings(gotVec)
-
-	got := strings.Join(gotVec, ",")
-	want := "Q123,Q777,Q987"
-	if got != want {
-		t.Errorf("got %v, want %v", got, want)
-	}
-}
-
 func TestContainsAny(t *testing.T) {
 	a := ClassSet{7: struct{}{}, 9: struct{}{}}
 	for _, tc := range []struct {
@@ -121,3 +88,31 @@ func TestContainsAny(t *testing.T) {
 		}
 	}
 }
+
+func TestItemClaimsRank(t *testing.T) {
+	ranked := func(rank mediawiki.StatementRank, qid string) mediawiki.Statement {
+		c := claim(mediawiki.WikiBaseEntityIDValue{ID: qid})
+		c.Rank = rank
+		return c
+	}
+	for _, tc := range []struct {
+		claims []mediawiki.Statement
+		want   []int64
+	}{
+		{nil, []int64{}},
+		{[]mediawiki.Statement{ranked(mediawiki.Normal, "Q1"), ranked(mediawiki.Normal, "Q2")}, []int64{1, 2}},
+		{[]mediawiki.Statement{ranked(mediawiki.Normal, "Q1"), ranked(mediawiki.Deprecated, "Q2")}, []int64{1}},
+		{[]mediawiki.Statement{ranked(mediawiki.Deprecated, "Q1")}, []int64{}},
+		{[]mediawiki.Statement{
+			ranked(mediawiki.Normal, "Q1"),
+			ranked(mediawiki.Preferred, "Q2"),
+			ranked(mediawiki.Deprecated, "Q3"),
+			ranked(mediawiki.Preferred, "Q4"),
+		}, []int64{2, 4}},
+	} {
+		e := mediawiki.Entity{Claims: map[string][]mediawiki.Statement{"P21": tc.claims}}
+		if got := ItemClaims(&e, "P21"); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("got %v, want %v", got, tc.want)
+		}
+	}
+}
@@ -4,59 +4,412 @@
 package main
 
 import (
-	"compress/gzip"
 	"context"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"gitlab.com/tozd/go/errors"
 	"gitlab.com/tozd/go/mediawiki"
+	"gitlab.com/tozd/go/x"
 )
 
 type Extractor struct {
-	dumpPath string
-	dumpDate time.Time
-	workdir  string
-	client   *http.Client
+	config     *Config
+	dumpPath   string
+	dumpDate   time.Time
+	workdir    string
+	subclasser Subclasser
+
+	// Workers is the number of goroutines for decompressing the dump,
+	// for processing its entities, and for sorting the extracts.
+	// Zero means one per CPU.
+	Workers int
+
+	// CheckpointInterval is the number of entities between two
+	// checkpoints. Writing a checkpoint briefly stalls processing.
+	CheckpointInterval int64
+
+	// Log receives progress reports while the dump is being processed.
+	Log io.Writer
+
+	// LexemesPath is the path of a lexemes dump, whose proper nouns
+	// supply the inflected forms of the extracted names. If empty,
+	// no forms get extracted. LexemesDate is the date of that dump.
+	LexemesPath string
+	LexemesDate time.Time
+
+	entities atomic.Int64
+	persons  *Persons // nil unless configured
 }
 
+// Output collects the names for one configured output, such as
+// "givennames". While the dump is being processed, names get appended
+// to a spool file, which can be truncated to the size recorded
+// in a checkpoint when resuming an interrupted run. Once all entities
+// are done, Close sorts the spooled names into the final extract.
+// If the output is configured for the "ndjson" format, the matched
+// items get spooled in the same way, and so do the names that got
+// rejected by the filter and the references of humans to the items
+// of the output, which Close counts to find the number of bearers.
 type Output struct {
-	path            string
-	file            io.WriteCloser
-	compressor      *gzip.Writer
-	nameWriter      *NameWriter
+	name            string
+	config          *OutputConfig
+	workdir         string
+	date            time.Time
+	mutex           sync.Mutex
+	names           *Spool
+	items           *Spool
+	rejected        *Spool
+	bearers         *Spool
+	rootClasses     ClassSet
 	wikidataClasses ClassSet
+	excludedClasses ClassSet
+	sources         []string
+	filter          *Filter
+	workers         int // for sorting; zero means one per CPU
+
+	// Set by Close, for writing the countries, genders and birth
+	// decades of name bearers.
+	countries map[nameCountry]int64
+	genders   map[int64]genderCounts
+	decades   map[nameDecade]int64
+
+	// Set by Close, diff and ExtractForms, for the manifest.
+	files     []string
+	rows      int64
+	itemCount int64
+	previous  time.Time
+	added     int64
+	removed   int64
+	forms     int64
 }
 
-func (o *Output) Close() error {
-	if err := o.nameWriter.Close(); err != nil {
+// WriteNames appends names to the spool file of the output.
+// It is safe to call WriteNames from multiple goroutines.
+func (o *Output) WriteNames(names []Name) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	for i := range names {
+		if err := o.names.Write(names[i].ToBytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteItem appends an item to the item spool of the output, if the
+// output is configured for the "ndjson" format. It is safe to call
+// WriteItem from multiple goroutines.
+func (o *Output) WriteItem(item *Item) error {
+	if o.items == nil {
+		return nil
+	}
+
+	l, err := item.line()
+	if err != nil {
 		return err
 	}
 
-	if err := o.compressor.Close(); err != nil {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.items.Write(l.ToBytes())
+}
+
+// WriteRejections appends rejected names to the spool file of the
+// output, if it has a filter. It is safe to call WriteRejections from
+// multiple goroutines.
+func (o *Output) WriteRejections(rejections []Rejection) error {
+	if o.rejected == nil {
+		return nil
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	for i := range rejections {
+		if err := o.rejected.Write(rejections[i].ToBytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteBearers spools the items that a human refers to with the
+// bearers property of the output, such as "given name" (P735),
+// together with the human's decade of birth (P569) and countries
+// of citizenship (P27). For given names, the human's sex or gender
+// (P21) gets spooled as well. Items and countries that are referenced
+// more than once get counted once. It is safe to call WriteBearers
+// from multiple goroutines.
+func (o *Output) WriteBearers(e *mediawiki.Entity) error {
+	if o.bearers == nil {
+		return nil
+	}
+
+	qids := ItemClaims(e, o.config.Bearers)
+	slices.Sort(qids)
+	qids = slices.Compact(qids)
+
+	countries := ItemClaims(e, "P27")
+	slices.Sort(countries)
+	countries = slices.Compact(countries)
+	g := genderUnknown
+	if o.config.Bearers == givenNameProperty {
+		g = genderOf(e)
+	}
+	decade, hasDecade := birthDecade(e)
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	for _, qid := range qids {
+		record := binary.AppendUvarint(nil, uint64(qid))
+		record = append(record, byte(g))
+		if hasDecade {
+			record = append(record, 1)
+			record = binary.AppendVarint(record, decade)
+		} else {
+			record = append(record, 0)
+		}
+		for _, c := range countries {
+			record = binary.AppendUvarint(record, uint64(c))
+		}
+		if err := o.bearers.Write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bearerCounts is what countBearers finds about the bearers of names.
+type bearerCounts struct {
+	total     map[int64]int64 // keyed by numeric Wikidata ID
+	countries map[nameCountry]int64
+	genders   map[int64]genderCounts
+	decades   map[nameDecade]int64
+}
+
+// countBearers returns the number of humans that refer to each item,
+// both in total and by country of citizenship, sex or gender, and
+// decade of birth.
+func (o *Output) countBearers() (*bearerCounts, error) {
+	counts := &bearerCounts{
+		total:     make(map[int64]int64, 100000),
+		countries: make(map[nameCountry]int64, 100000),
+		genders:   make(map[int64]genderCounts, 100000),
+		decades:   make(map[nameDecade]int64, 100000),
+	}
+	if o.bearers == nil {
+		return counts, nil
+	}
+	err := o.bearers.ReadAll(func(record []byte) error {
+		qid, n := binary.Uvarint(record)
+		if n <= 0 || n+1 >= len(record) {
+			return fmt.Errorf("%s: bad record", o.name)
+		}
+		name := int64(qid)
+		counts.total[name] += 1
+		if g := gender(record[n]); g != genderUnknown {
+			c := counts.genders[name]
+			c.add(g)
+			counts.genders[name] = c
+		}
+		hasDecade := record[n+1] == 1
+		record = record[n+2:]
+		if hasDecade {
+			var decade int64
+			if decade, n = binary.Varint(record); n <= 0 {
+				return fmt.Errorf("%s: bad record", o.name)
+			}
+			counts.decades[nameDecade{name, decade}] += 1
+			record = record[n:]
+		}
+		for ; len(record) > 0; record = record[n:] {
+			var c uint64
+			if c, n = binary.Uvarint(record); n <= 0 {
+				return fmt.Errorf("%s: bad record", o.name)
+			}
+			counts.countries[nameCountry{name, int64(c)}] += 1
+		}
+		return nil
+	})
+	return counts, err
+}
+
+// Sync writes the spooled data to stable storage and records
+// the size of the spool files in a checkpoint.
+func (o *Output) Sync(cp *Checkpoint) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	for key, spool := range o.spools() {
+		size, err := spool.Sync()
+		if err != nil {
+			return err
+		}
+		cp.Spools[key] = size
+	}
+	return nil
+}
+
+// Truncate drops everything that got spooled after a checkpoint.
+func (o *Output) Truncate(cp *Checkpoint) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	for key, spool := range o.spools() {
+		if err := spool.Truncate(cp.Spools[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// spools returns the spools of the output, keyed by the name under
+// which the checkpoint records their size.
+func (o *Output) spools() map[string]*Spool {
+	spools := map[string]*Spool{o.name: o.names}
+	if o.items != nil {
+		spools[o.name+"-items"] = o.items
+	}
+	if o.rejected != nil {
+		spools[o.name+"-rejected"] = o.rejected
+	}
+	if o.bearers != nil {
+		spools[o.name+"-bearers"] = o.bearers
+	}
+	return spools
+}
+
+// Close sorts the spooled data into the final extract files. The spool
+// files are kept so that Close can be repeated after a crash; callers
+// should call RemoveSpools once all outputs have been closed.
+func (o *Output) Close() error {
+	// The CSV file gets written last, because its presence
+	// tells that the extract is complete.
+	o.files = nil
+	if o.items != nil {
+		path := itemsPath(o.workdir, o.name, o.date)
+		writer, err := NewItemWriter(path)
+		if err != nil {
+			return err
+		}
+		err = o.items.ReadAll(func(record []byte) error {
+			return writer.write(itemLineFromBytes(record).(itemLine))
+		})
+		if err != nil {
+			writer.Abort()
+			return err
+		}
+		if err := writer.Close(); err != nil {
+			return err
+		}
+		o.files = append(o.files, path)
+	}
+
+	writer, err := NewExtractWriter(o.workdir, o.config, o.date, o.workers)
+	if err != nil {
 		return err
 	}
 
-	if err := o.file.Close(); err != nil {
+	bearers, err := o.countBearers()
+	if err != nil {
+		writer.Abort()
 		return err
 	}
+	items := make(map[int64]struct{}, len(bearers.total))
+	err = o.names.ReadAll(func(record []byte) error {
+		n, err := NameFromBytes(record)
+		if err != nil {
+			return fmt.Errorf("%s: %v", o.name, err)
+		}
+		if qid, err := parseQID(n.ID); err == nil {
+			n.Bearers = bearers.total[qid]
+			items[qid] = struct{}{}
+		}
+		return writer.WriteName(&n)
+	})
+	if err != nil {
+		writer.Abort()
+		return err
+	}
+
+	// Humans can refer to items that are not names of this output,
+	// such as when an item for a given name is used as family name.
+	for k := range bearers.countries {
+		if _, ok := items[k.name]; !ok {
+			delete(bearers.countries, k)
+		}
+	}
+	for qid := range bearers.genders {
+		if _, ok := items[qid]; !ok {
+			delete(bearers.genders, qid)
+		}
+	}
+	for k := range bearers.decades {
+		if _, ok := items[k.name]; !ok {
+			delete(bearers.decades, k)
+		}
+	}
+	o.countries, o.genders, o.decades = bearers.countries, bearers.genders, bearers.decades
 
-	if err := os.Rename(o.path+".tmp", o.path); err != nil {
+	if err := writer.Close(); err != nil {
 		return err
 	}
+	o.files = append(o.files, writer.Paths()...)
+	o.rows, o.itemCount = writer.Counts()
 
+	for _, spool := range o.spools() {
+		if err := spool.Close(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func ShouldRun(dumpDate time.Time, workdir string) (bool, error) {
-	day := dumpDate.Format("20060102")
-	for _, n := range []string{"familynames", "givennames"} {
-		path := filepath.Join(workdir, fmt.Sprintf("%s-%s.csv.gz", n, day))
-		_, err := os.Stat(path)
+func (o *Output) RemoveSpools() error {
+	for _, spool := range o.spools() {
+		if err := spool.Remove(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractPath returns the path of an extract, such as
+// "givennames-20230418.csv.gz" in the working directory.
+func extractPath(workdir string, name string, date time.Time) string {
+	day := date.Format("20060102")
+	return filepath.Join(workdir, fmt.Sprintf("%s-%s.csv.gz", name, day))
+}
+
+// itemsPath returns the path of the NDJSON output of an extract,
+// such as "givennames-20230418.ndjson.gz" in the working directory.
+func itemsPath(workdir string, name string, date time.Time) string {
+	day := date.Format("20060102")
+	return filepath.Join(workdir, fmt.Sprintf("%s-%s.ndjson.gz", name, day))
+}
+
+func ShouldRun(config *Config, dumpDate time.Time, workdir string) (bool, error) {
+	if _, err := os.Stat(checkpointPath(workdir, dumpDate)); err == nil {
+		return true, nil
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	for _, o := range config.Outputs {
+		_, err := os.Stat(extractPath(workdir, o.Name, dumpDate))
 		if err == nil {
 			continue
 		}
@@ -68,90 +421,635 @@ func ShouldRun(dumpDate time.Time, workdir string) (bool, error) {
 	return false, nil
 }
 
-func NewExtractor(dumpPath string, dumpDate time.Time, workdir string, client *http.Client) (*Extractor, error) {
+func NewExtractor(config *Config, dumpPath string, dumpDate time.Time, workdir string, subclasser Subclasser) (*Extractor, error) {
 	return &Extractor{
-		dumpPath: dumpPath,
-		dumpDate: dumpDate,
-		workdir:  workdir,
-		client:   client,
+		config:             config,
+		dumpPath:           dumpPath,
+		dumpDate:           dumpDate,
+		workdir:            workdir,
+		subclasser:         subclasser,
+		CheckpointInterval: 1000000,
+		Log:                os.Stderr,
 	}, nil
 }
 
-func NewOutput(dumpDate time.Time, workdir string, filename string, wikidataClassID int64, client *http.Client) (*Output, error) {
-	day := dumpDate.Format("20060102")
-	path := filepath.Join(workdir, fmt.Sprintf("%s-%s.csv.gz", filename, day))
-	file, err := os.Create(path + ".tmp")
-	if err != nil {
-		return nil, err
+// ResolveClasses returns the root classes of an output, the set of
+// all classes whose instances go into the output, and the set of
+// excluded classes. An entity that is an instance of any excluded
+// class stays out of the output, even if it is also an instance of
+// an included class. Excluded classes are not in the included set.
+func ResolveClasses(config *OutputConfig, subclasser Subclasser) (ClassSet, ClassSet, ClassSet, error) {
+	rootClasses := make(ClassSet, len(config.Classes))
+	wikidataClasses := make(ClassSet, 1000)
+	for _, c := range config.ClassIDs() {
+		rootClasses[c] = struct{}{}
+		subclasses, err := subclasser.Subclasses(c)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for sub, _ := range subclasses {
+			wikidataClasses[sub] = struct{}{}
+		}
+	}
+	excludedClasses := make(ClassSet, 100)
+	for _, c := range config.ExcludeIDs() {
+		subclasses, err := subclasser.Subclasses(c)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for sub, _ := range subclasses {
+			excludedClasses[sub] = struct{}{}
+			delete(wikidataClasses, sub)
+		}
 	}
+	return rootClasses, wikidataClasses, excludedClasses, nil
+}
 
-	compressor, err := gzip.NewWriterLevel(file, 9)
+func NewOutput(dumpDate time.Time, workdir string, config *OutputConfig, filter *Filter, subclasser Subclasser, workers int) (*Output, error) {
+	rootClasses, wikidataClasses, excludedClasses, err := ResolveClasses(config, subclasser)
 	if err != nil {
 		return nil, err
 	}
 
-	nameWriter, err := NewNameWriter(compressor)
+	names, err := OpenSpool(spoolPath(workdir, config.Name, dumpDate))
 	if err != nil {
 		return nil, err
 	}
 
-	wikidataClasses, err := QuerySubclasses(wikidataClassID, client)
-	if err != nil {
-		return nil, err
+	var items *Spool
+	for _, format := range config.Formats {
+		if format == "ndjson" {
+			items, err = OpenSpool(spoolPath(workdir, config.Name+"-items", dumpDate))
+			if err != nil {
+				names.Close()
+				return nil, err
+			}
+		}
 	}
 
-	o := Output{path, file, compressor, nameWriter, wikidataClasses}
+	var rejected *Spool
+	if filter != nil {
+		rejected, err = OpenSpool(spoolPath(workdir, config.Name+"-rejected", dumpDate))
+		if err != nil {
+			names.Close()
+			if items != nil {
+				items.Close()
+			}
+			return nil, err
+		}
+	}
+
+	var bearers *Spool
+	if config.Bearers != "" {
+		bearers, err = OpenSpool(spoolPath(workdir, config.Name+"-bearers", dumpDate))
+		if err != nil {
+			for _, spool := range []*Spool{names, items, rejected} {
+				if spool != nil {
+					spool.Close()
+				}
+			}
+			return nil, err
+		}
+	}
+
+	o := Output{
+		name:            config.Name,
+		config:          config,
+		workdir:         workdir,
+		date:            dumpDate,
+		names:           names,
+		items:           items,
+		rejected:        rejected,
+		bearers:         bearers,
+		rootClasses:     rootClasses,
+		wikidataClasses: wikidataClasses,
+		excludedClasses: excludedClasses,
+		sources:         config.Sources,
+		filter:          filter,
+		workers:         workers,
+	}
 	return &o, nil
 }
 
-func (ex *Extractor) Run() error {
-	outputs := make([]*Output, 0)
-	for _, s := range []struct {
-		filename        string
-		wikidataClassID int64
-	}{
-		{"familynames", 101352},
-		{"givennames", 202444},
-	} {
-		o, err := NewOutput(ex.dumpDate, ex.workdir, s.filename, s.wikidataClassID, ex.client)
+// Run extracts names from the dump. If a previous run for the same
+// dump got interrupted, Run resumes after its last checkpoint.
+func (ex *Extractor) Run(ctx context.Context) error {
+	started := time.Now()
+	setSubclasserLog(ex.subclasser, ex.Log)
+	if err := CleanStale(ex.config, ex.workdir, ex.dumpDate); err != nil {
+		return err
+	}
+
+	cp := NewCheckpoint(ex.config, ex.dumpPath)
+	old, err := ReadCheckpoint(ex.workdir, ex.dumpDate)
+	if err != nil {
+		return err
+	}
+	if old != nil && old.Matches(cp) {
+		cp = old
+		fmt.Fprintf(ex.Log, "resuming after %d entities\n", cp.Entities)
+	} else if old != nil {
+		fmt.Fprintln(ex.Log, "starting over, checkpoint is for another dump or configuration")
+	}
+
+	filter := NewFilter(ex.config.Filters)
+	outputs := make([]*Output, 0, len(ex.config.Outputs))
+	// After an error, the spools still need closing. Once they got
+	// closed by Output.Close and Persons.Close, this does nothing.
+	defer func() {
+		for _, o := range outputs {
+			for _, spool := range o.spools() {
+				spool.Close()
+			}
+		}
+		if ex.persons != nil {
+			ex.persons.spool.Close()
+		}
+	}()
+	for i := range ex.config.Outputs {
+		o, err := NewOutput(ex.dumpDate, ex.workdir, &ex.config.Outputs[i], filter, ex.subclasser, ex.Workers)
 		if err != nil {
 			return err
 		}
 		outputs = append(outputs, o)
+		if err := o.Truncate(cp); err != nil {
+			return err
+		}
+	}
+	ex.persons = nil
+	if ex.config.Persons {
+		if ex.persons, err = NewPersons(ex.workdir, ex.dumpDate, outputs); err != nil {
+			return err
+		}
+		if err := ex.persons.Truncate(cp); err != nil {
+			return err
+		}
 	}
 
-	err := mediawiki.ProcessWikidataDump(
-		context.Background(),
-		&mediawiki.ProcessDumpConfig{
-			Path: ex.dumpPath,
-		},
-		func(_ context.Context, e mediawiki.Entity) errors.E {
-			entityClasses := WikidataClasses(&e)
-			for _, o := range outputs {
-				if entityClasses.ContainsAny(&o.wikidataClasses) {
-					names := make(map[string]struct{}, len(e.Labels))
-					for _, langval := range e.Labels {
-						names[langval.Value] = struct{}{}
-					}
-					for name, _ := range names {
-						n := Name{Name: name, ID: e.ID}
-						if err := o.nameWriter.WriteName(&n); err != nil {
-							return errors.WithStack(err)
-						}
+	start := time.Now()
+	if err := ex.process(ctx, cp, outputs); err != nil {
+		return err
+	}
+
+	manifest := Manifest{
+		DumpPath:    ex.dumpPath,
+		DumpDate:    ex.dumpDate.Format("2006-01-02"),
+		ToolVersion: toolVersion(),
+		Started:     started.UTC().Truncate(time.Second),
+		Outputs:     make([]ManifestOutput, 0, len(outputs)),
+	}
+	// The rejected names go first, because Close closes the spools.
+	if filter != nil {
+		path := rejectedPath(ex.workdir, ex.dumpDate)
+		count, err := WriteRejections(path, outputs)
+		if err != nil {
+			return err
+		}
+		f, err := manifestFile(path)
+		if err != nil {
+			return err
+		}
+		manifest.Rejected, manifest.RejectedFile = count, &f
+	}
+	// If there is an earlier extract, we list the names that got
+	// added or removed since then. Extracts by old versions of this
+	// tool may be sorted differently, and cannot be compared.
+	previous, err := FindPreviousExtract(ex.config, ex.workdir, ex.dumpDate)
+	if err != nil {
+		if !errors.Is(err, errNoPreviousExtract) {
+			fmt.Fprintf(ex.Log, "cannot find previous extract: %v\n", err)
+		}
+		previous = time.Time{}
+	}
+	for _, o := range outputs {
+		if err := o.Close(); err != nil {
+			return err
+		}
+	}
+	if slices.ContainsFunc(outputs, func(o *Output) bool { return o.bearers != nil }) {
+		path := countriesPath(ex.workdir, ex.dumpDate)
+		count, err := WriteCountries(path, outputs)
+		if err != nil {
+			return err
+		}
+		f, err := manifestFile(path)
+		if err != nil {
+			return err
+		}
+		manifest.Countries, manifest.CountriesFile = count, &f
+
+		gendersPath := gendersPath(ex.workdir, ex.dumpDate)
+		genders, err := WriteGenders(gendersPath, outputs)
+		if err != nil {
+			return err
+		}
+		gendersFile, err := manifestFile(gendersPath)
+		if err != nil {
+			return err
+		}
+		manifest.Genders, manifest.GendersFile = genders, &gendersFile
+
+		decadesPath := decadesPath(ex.workdir, ex.dumpDate)
+		decades, err := WriteDecades(decadesPath, outputs)
+		if err != nil {
+			return err
+		}
+		decadesFile, err := manifestFile(decadesPath)
+		if err != nil {
+			return err
+		}
+		manifest.Decades, manifest.DecadesFile = decades, &decadesFile
+	}
+	if ex.persons != nil {
+		rows, err := ex.persons.Close()
+		if err != nil {
+			return err
+		}
+		f, err := manifestFile(personsPath(ex.workdir, ex.dumpDate))
+		if err != nil {
+			return err
+		}
+		manifest.Persons, manifest.PersonsFile = rows, &f
+	}
+	if ex.LexemesPath != "" {
+		if err := ExtractForms(ctx, ex.LexemesPath, outputs, ex.Workers); err != nil {
+			return err
+		}
+		manifest.LexemesPath = ex.LexemesPath
+		if !ex.LexemesDate.IsZero() {
+			manifest.LexemesDate = ex.LexemesDate.Format("2006-01-02")
+		}
+	}
+	for _, o := range outputs {
+		if !previous.IsZero() {
+			if err := o.diff(previous); errors.Is(err, errIncompatible) {
+				fmt.Fprintf(ex.Log, "cannot compare to previous extract: %v\n", err)
+			} else if err != nil {
+				return err
+			}
+		}
+		m, err := o.manifest()
+		if err != nil {
+			return err
+		}
+		manifest.Outputs = append(manifest.Outputs, m)
+	}
+	manifest.Duration = time.Since(started).Round(time.Second).Seconds()
+	if err := manifest.Write(ex.workdir, ex.dumpDate); err != nil {
+		return err
+	}
+
+	if err := os.Remove(checkpointPath(ex.workdir, ex.dumpDate)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, o := range outputs {
+		if err := o.RemoveSpools(); err != nil {
+			return err
+		}
+	}
+	if ex.persons != nil {
+		if err := ex.persons.Remove(); err != nil {
+			return err
+		}
+	}
+
+	elapsed := time.Since(start)
+	n := ex.entities.Load()
+	fmt.Fprintf(ex.Log, "processed %d entities in %s, %.0f entities/s\n",
+		n, elapsed.Round(time.Second), float64(n)/elapsed.Seconds())
+
+	return nil
+}
+
+// rawEntity is the undecoded JSON of an entity. The dump reader
+// hands out entities in dump order, with little more work than
+// splitting the JSON array. The expensive decoding happens in our
+// own workers.
+type rawEntity []byte
+
+func (r *rawEntity) UnmarshalJSON(b []byte) error {
+	*r = append(rawEntity(nil), b...)
+	return nil
+}
+
+// process streams the dump through a pool of workers. If the
+// checkpoint has a position in the dump, reading starts there;
+// otherwise, the entities covered by the checkpoint get skipped.
+// Every CheckpointInterval entities, we wait for the workers to
+// finish what they have been given, and then record a new checkpoint.
+func (ex *Extractor) process(ctx context.Context, cp *Checkpoint, outputs []*Output) error {
+	workers := ex.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	progress := func(p x.Progress) {
+		fmt.Fprintln(ex.Log, formatProgress(p, ex.entities.Load()))
+	}
+	dump, err := openDump(ctx, ex.dumpPath, cp.Position, workers, progress)
+	if err != nil && cp.Position != nil {
+		fmt.Fprintf(ex.Log, "reading dump from the start: %v\n", err)
+		cp.Position = nil
+		dump, err = openDump(ctx, ex.dumpPath, nil, workers, progress)
+	}
+	if err != nil {
+		return err
+	}
+	defer dump.Close()
+
+	entities := make(chan rawEntity, workers*4)
+	var pending sync.WaitGroup
+	var workersDone sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		workersDone.Add(1)
+		go func() {
+			defer workersDone.Done()
+			for raw := range entities {
+				if ctx.Err() == nil {
+					if err := ex.processEntity(raw, outputs); err != nil {
+						cancel(err)
 					}
 				}
+				pending.Done()
 			}
+		}()
+	}
+
+	ex.entities.Store(0)
+	if cp.Position != nil {
+		ex.entities.Store(cp.Entities)
+	}
+	err = dump.Entities(func(raw rawEntity) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		seq := ex.entities.Add(1)
+		if seq <= cp.Entities {
 			return nil
-		})
+		}
+
+		pending.Add(1)
+		entities <- raw
+		if ex.CheckpointInterval > 0 && seq%ex.CheckpointInterval == 0 {
+			pending.Wait()
+			if err := context.Cause(ctx); err != nil {
+				return err
+			}
+			return ex.checkpoint(cp, seq, dump.Position(), outputs)
+		}
+		return nil
+	})
+
+	close(entities)
+	workersDone.Wait()
+	if err := context.Cause(ctx); err != nil {
+		return err
+	}
 	if err != nil {
 		return err
 	}
+	return nil
+}
+
+// The class of human beings, "human" (Q5). Only its direct instances
+// count as bearers of names; fictional humans do not.
+const human = 5
+
+func (ex *Extractor) processEntity(raw rawEntity, outputs []*Output) error {
+	var e mediawiki.Entity
+	if err := json.Unmarshal(raw, &e); err != nil {
+		return err
+	}
 
+	entityClasses := WikidataClasses(&e)
+	_, isHuman := entityClasses[human]
+	if isHuman && ex.persons != nil {
+		if err := ex.persons.Write(&e); err != nil {
+			return err
+		}
+	}
 	for _, o := range outputs {
-		if err := o.Close(); err != nil {
+		if isHuman {
+			if err := o.WriteBearers(&e); err != nil {
+				return err
+			}
+		}
+		if !entityClasses.ContainsAny(&o.wikidataClasses) || o.excludedClasses.ContainsAny(&entityClasses) {
+			continue
+		}
+		classes := MostSpecificClasses(entityClasses, o.wikidataClasses, o.rootClasses)
+		names, rejections := SelectNames(&e, classes, o.sources, o.filter)
+		if err := o.WriteNames(names); err != nil {
+			return err
+		}
+		if err := o.WriteRejections(rejections); err != nil {
+			return err
+		}
+		if err := o.WriteItem(NewItem(&e, classes)); err != nil {
 			return err
 		}
 	}
+	return nil
+}
 
+// SelectNames returns the names that an entity contributes to an
+// output, given the classes that were matched by the output,
+// together with the spellings that got rejected by filter.
+func SelectNames(e *mediawiki.Entity, classes []string, sources []string, filter *Filter) ([]Name, []Rejection) {
+	names, rejections := EntityNames(e, sources, filter)
+	for i := range names {
+		names[i].Classes = classes
+	}
+	return names, rejections
+}
+
+func (ex *Extractor) checkpoint(cp *Checkpoint, entities int64, pos *DumpPosition, outputs []*Output) error {
+	for _, o := range outputs {
+		if err := o.Sync(cp); err != nil {
+			return err
+		}
+	}
+	if ex.persons != nil {
+		if err := ex.persons.Sync(cp); err != nil {
+			return err
+		}
+	}
+	cp.Entities = entities
+	cp.Position = pos
+	if err := cp.Write(ex.workdir, ex.dumpDate); err != nil {
+		return err
+	}
+	fmt.Fprintf(ex.Log, "checkpoint after %d entities\n", entities)
 	return nil
 }
+
+// formatProgress returns a human-readable report about the progress
+// of processing a dump. Because the dump is compressed, p.Count and
+// p.Size are measured in compressed bytes.
+func formatProgress(p x.Progress, entities int64) string {
+	secs := p.Elapsed.Seconds()
+	if secs <= 0 {
+		return fmt.Sprintf("%.1f%% done, %d entities", p.Percent(), entities)
+	}
+	return fmt.Sprintf("%.1f%% done, %d entities, %.0f entities/s, %.1f MB/s, %s remaining",
+		p.Percent(), entities, float64(entities)/secs, float64(p.Count)/secs/1e6,
+		p.Remaining().Round(time.Second))
+}
+
+// MostSpecificClasses returns the classes of an entity that are in
+// the class set of an output, such as "Q11879590" (female given name)
+// for the givennames output. Because "instance of" (P31) claims normally
+// point to the most specific class already, we only need to drop the
+// output's root classes if the entity also belongs to one of their
+// subclasses.
+func MostSpecificClasses(entityClasses, outputClasses, rootClasses ClassSet) []string {
+	matched := make([]int64, 0, len(entityClasses))
+	hasSubclass := false
+	for c, _ := range entityClasses {
+		if _, ok := outputClasses[c]; ok {
+			matched = append(matched, c)
+			if _, isRoot := rootClasses[c]; !isRoot {
+				hasSubclass = true
+			}
+		}
+	}
+	if hasSubclass {
+		specific := matched[:0]
+		for _, c := range matched {
+			if _, isRoot := rootClasses[c]; !isRoot {
+				specific = append(specific, c)
+			}
+		}
+		matched = specific
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i] < matched[j] })
+
+	result := make([]string, 0, len(matched))
+	for _, c := range matched {
+		result = append(result, fmt.Sprintf("Q%d", c))
+	}
+	return result
+}
+
+// Some properties, such as "native label" (P1705), have monolingual text
+// values that carry their own language. Others, such as transliterations,
+// are plain strings; for these, we record the language that the property
+// is about.
+var propertyLanguages = map[string]string{
+	"P1721": "zh", // pinyin transliteration
+	"P1814": "ja", // name in kana
+	"P1942": "ko", // McCune-Reischauer romanization
+	"P2125": "ja", // Revised Hepburn romanization
+}
+
+// EntityNames returns the names of a Wikidata entity, taken from the
+// passed sources: "label", "alias", or the ID of a property whose claims
+// are additional spellings, such as "P1705" (native label). Spellings
+// that are shared by several languages get merged into a single Name.
+// If the entity has "writing system" (P282) statements, they get
+// recorded in every returned Name. Spellings are checked by filter,
+// which may be nil; those it rejects get returned separately.
+func EntityNames(e *mediawiki.Entity, sources []string, filter *Filter) ([]Name, []Rejection) {
+	type key struct {
+		name   string
+		source string
+	}
+	type rejectedKey struct {
+		key
+		reason string
+	}
+	langs := make(map[key][]string, len(e.Labels))
+	rejected := make(map[rejectedKey][]string)
+	add := func(value string, source string, lang string) {
+		orig := NormalizeName(value)
+		name, reason := filter.Apply(orig)
+		if reason != "" {
+			k := rejectedKey{key{orig, source}, reason}
+			rejected[k] = append(rejected[k], lang)
+			return
+		}
+		k := key{name, source}
+		langs[k] = append(langs[k], lang)
+	}
+	for _, source := range sources {
+		switch source {
+		case "label":
+			for lang, label := range e.Labels {
+				add(label.Value, source, lang)
+			}
+
+		case "alias":
+			for lang, aliases := range e.Aliases {
+				for _, alias := range aliases {
+					add(alias.Value, source, lang)
+				}
+			}
+
+		default:
+			for _, v := range ClaimValues(e, source) {
+				switch val := v.(type) {
+				case mediawiki.MonolingualTextValue:
+					add(val.Text, source, val.Language)
+				case mediawiki.StringValue:
+					add(string(val), source, propertyLanguages[source])
+				}
+			}
+		}
+	}
+
+	var writingSystems []string
+	ws := ItemClaims(e, "P282")
+	sort.Slice(ws, func(i, j int) bool { return ws[i] < ws[j] })
+	for _, qid := range ws {
+		writingSystems = append(writingSystems, fmt.Sprintf("Q%d", qid))
+	}
+
+	names := make([]Name, 0, len(langs))
+	for k, l := range langs {
+		// Names that only consisted of whitespace become empty
+		// when normalized. Spelling variants that normalize to
+		// the same name can repeat a language.
+		if k.name == "" {
+			continue
+		}
+		names = append(names, Name{
+			Name:           k.name,
+			ID:             e.ID,
+			Languages:      sortLanguages(l),
+			Source:         k.source,
+			Scripts:        Scripts(k.name),
+			WritingSystems: writingSystems,
+			Folded:         FoldName(k.name),
+		})
+	}
+
+	var rejections []Rejection
+	for k, l := range rejected {
+		rejections = append(rejections, Rejection{
+			Name:      k.name,
+			ID:        e.ID,
+			Languages: sortLanguages(l),
+			Source:    k.source,
+			Reason:    k.reason,
+		})
+	}
+	return names, rejections
+}
+
+// sortLanguages sorts the languages of a name and removes repetitions,
+// as well as the empty language of property values without one.
+func sortLanguages(langs []string) []string {
+	sort.Strings(langs)
+	langs = slices.Compact(langs)
+	if len(langs) > 0 && langs[0] == "" {
+		langs = langs[1:]
+	}
+	if len(langs) == 0 {
+		return nil
+	}
+	return langs
+}
@@ -6,20 +6,32 @@ package main
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
+	"encoding/csv"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 	"testing"
 	"time"
+
+	"gitlab.com/tozd/go/mediawiki"
+	"gitlab.com/tozd/go/x"
 )
 
 func TestShouldRun(t *testing.T) {
 	dumpDate, _ := time.Parse(time.RFC3339, "2023-04-18T23:22:21Z")
 	workdir := t.TempDir()
-	if got, err := ShouldRun(dumpDate, workdir); err != nil {
+	config, err := ReadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := ShouldRun(config, dumpDate, workdir); err != nil {
 		t.Error(err)
 		return
 	} else if got != true {
@@ -37,7 +49,7 @@ func TestShouldRun(t *testing.T) {
 		return
 	}
 
-	if got, err := ShouldRun(dumpDate, workdir); err != nil {
+	if got, err := ShouldRun(config, dumpDate, workdir); err != nil {
 		t.Error(err)
 		return
 	} else if got != true {
@@ -55,13 +67,25 @@ func TestShouldRun(t *testing.T) {
 		return
 	}
 
-	if got, err := ShouldRun(dumpDate, workdir); err != nil {
+	if got, err := ShouldRun(config, dumpDate, workdir); err != nil {
 		t.Error(err)
 		return
 	} else if got != false {
 		t.Errorf("expected false, got %v, workdir=%v", got, workdir)
 		return
 	}
+
+	// An interrupted run needs to be resumed, even if all
+	// outputs were already in place.
+	cp := NewCheckpoint(config, "dump.json.bz2")
+	if err := cp.Write(workdir, dumpDate); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ShouldRun(config, dumpDate, workdir); err != nil {
+		t.Error(err)
+	} else if got != true {
+		t.Errorf("expected true with checkpoint, got %v", got)
+	}
 }
 
 func TestExtractor(t *testing.T) {
@@ -98,36 +122,358 @@ func TestExtractor(t *testing.T) {
 		}
 	})
 
-	ex, err := NewExtractor(dumpPath, dumpDate, workdir, client)
+	config, err := ReadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ex, err := NewExtractor(config, dumpPath, dumpDate, workdir, NewQueryService(client, workdir))
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	if err := ex.Run(); err != nil {
+	if err := ex.Run(context.Background()); err != nil {
 		t.Error(err)
 		return
 	}
 
-	for _, f := range []string{"givennames", "familynames"} {
-		gotPath := filepath.Join(workdir, fmt.Sprintf("%s-20230418.csv.gz", f))
-		stream, err := os.Open(gotPath)
+	checkExtracts(t, workdir)
+	checkManifest(t, workdir, dumpDate)
+}
+
+// Compares the manifest in workdir to the extracts it describes.
+func checkManifest(t *testing.T, workdir string, dumpDate time.Time) {
+	m, err := ReadManifest(workdir, dumpDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.DumpDate != "2023-04-18" || !strings.HasSuffix(m.DumpPath, "entities.json.bz2") {
+		t.Errorf("got dump %q %q", m.DumpDate, m.DumpPath)
+	}
+	if len(m.Outputs) != 2 {
+		t.Fatalf("got %d outputs, want 2", len(m.Outputs))
+	}
+	if m.Rejected != 0 || m.RejectedFile == nil || m.RejectedFile.Name != "rejected-20230418.csv.gz" {
+		t.Errorf("got %d rejected names in %v", m.Rejected, m.RejectedFile)
+	}
+	for _, o := range m.Outputs {
+		csvName := fmt.Sprintf("%s-20230418.csv.gz", o.Name)
+		extract, err := readExtract(filepath.Join(workdir, csvName))
 		if err != nil {
-			t.Error(err)
-			return
+			t.Fatal(err)
 		}
-		defer stream.Close()
-		gzStream, err := gzip.NewReader(stream)
+		records, err := csv.NewReader(strings.NewReader(extract)).ReadAll()
 		if err != nil {
-			t.Error(err)
-			return
+			t.Fatal(err)
+		}
+		items := make(map[string]bool)
+		for _, r := range records[1:] {
+			items[r[1]] = true
+		}
+		if o.Rows != int64(len(records)-1) || o.Items != int64(len(items)) {
+			t.Errorf("%s: got %d rows and %d items, want %d and %d",
+				o.Name, o.Rows, o.Items, len(records)-1, len(items))
+		}
+		if len(o.RootClasses) != 1 || !slices.Contains(o.Classes, o.RootClasses[0]) {
+			t.Errorf("%s: got classes %v, root classes %v", o.Name, o.Classes, o.RootClasses)
+		}
+
+		var names []string
+		for _, f := range o.Files {
+			names = append(names, f.Name)
+			size, hash, err := hashFile(filepath.Join(workdir, f.Name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.Size != size || f.SHA256 != hash {
+				t.Errorf("%s: got %d %s, want %d %s", f.Name, f.Size, f.SHA256, size, hash)
+			}
 		}
-		gotBytes, err := io.ReadAll(gzStream)
+		if names[len(names)-1] != csvName {
+			t.Errorf("%s: got files %v, want %s last", o.Name, names, csvName)
+		}
+	}
+}
+
+// With -local-subclasses, the extractor finds subclasses in the dump.
+// For the test, we read them from a separate dump because the one
+// in testdata/full contains no class items.
+func TestExtractorLocalSubclasses(t *testing.T) {
+	workdir := t.TempDir()
+	dumpPath := filepath.Join("testdata", "full", "entities.json.bz2")
+	dumpDate, err := time.Parse(time.RFC3339, "2023-04-18T23:22:21Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := ReadClassTree(filepath.Join("testdata", "classes", "entities.json.bz2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ReadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ex, err := NewExtractor(config, dumpPath, dumpDate, workdir, tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ex.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	checkExtracts(t, workdir)
+}
+
+func TestExtractorWorkers(t *testing.T) {
+	for _, workers := range []int{1, 3} {
+		workdir := t.TempDir()
+		dumpPath := filepath.Join("testdata", "full", "entities.json.bz2")
+		dumpDate, err := time.Parse(time.RFC3339, "2023-04-18T23:22:21Z")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tree, err := ReadClassTree(filepath.Join("testdata", "classes", "entities.json.bz2"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		config, err := ReadConfig("")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ex, err := NewExtractor(config, dumpPath, dumpDate, workdir, tree)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var log bytes.Buffer
+		ex.Workers = workers
+		ex.Log = &log
+		if err := ex.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.HasPrefix(log.String(), "processed 8 entities in ") {
+			t.Errorf("workers=%d: got log %q", workers, log.String())
+		}
+		checkExtracts(t, workdir)
+	}
+}
+
+func TestExtractorResume(t *testing.T) {
+	workdir := t.TempDir()
+	dumpPath := filepath.Join("testdata", "full", "entities.json.bz2")
+	dumpDate, err := time.Parse(time.RFC3339, "2023-04-18T23:22:21Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A leftover from an earlier, unrelated crash.
+	stale := filepath.Join(workdir, "givennames-20230411.csv.gz.tmp")
+	if err := os.WriteFile(stale, []byte("junk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, tree := interruptExtract(t, dumpPath, dumpDate, workdir)
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed, got %v", stale, err)
+	}
+	if got, err := ShouldRun(config, dumpDate, workdir); err != nil || got != true {
+		t.Errorf("expected ShouldRun() to return true after interruption, got %v %v", got, err)
+	}
+	cp, err := ReadCheckpoint(workdir, dumpDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp == nil || cp.Entities != 2 {
+		t.Fatalf("expected checkpoint after 2 entities, got %v", cp)
+	}
+	if cp.Position == nil || cp.Position.Skip == 0 {
+		t.Errorf("expected checkpoint to have position in dump, got %v", cp.Position)
+	}
+	keys := make([]string, 0, len(cp.Spools))
+	for k := range cp.Spools {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	if !slices.Equal(keys, cp.SpoolKeys) {
+		t.Errorf("checkpoint has spools %v, but lists %v", keys, cp.SpoolKeys)
+	}
+
+	ex, err := NewExtractor(config, dumpPath, dumpDate, workdir, tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log bytes.Buffer
+	ex.Log = &log
+	if err := ex.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(log.String(), "resuming after 2 entities\n") {
+		t.Errorf("expected run to resume, got log %q", log.String())
+	}
+	checkExtracts(t, workdir)
+
+	entries, err := os.ReadDir(workdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, e := range entries {
+		files = append(files, e.Name())
+	}
+	got := strings.Join(files, " ")
+	want := "familynames-20230418.csv.gz familynames-20230418.ndjson.gz familynames-20230418.parquet familynames-20230418.sqlite " +
+		"givennames-20230418.csv.gz givennames-20230418.ndjson.gz givennames-20230418.parquet givennames-20230418.sqlite " +
+		"manifest-20230418.json name-countries-20230418.csv.gz name-decades-20230418.csv.gz name-genders-20230418.csv.gz persons-20230418.csv.gz rejected-20230418.csv.gz"
+	if got != want {
+		t.Errorf("got files %q, want %q", got, want)
+	}
+}
+
+// If the checkpoint's position in the dump does not point to
+// a compressed block, a resumed run reads the dump from its start.
+func TestExtractorResumeBadPosition(t *testing.T) {
+	workdir := t.TempDir()
+	dumpPath := filepath.Join("testdata", "full", "entities.json.bz2")
+	dumpDate, err := time.Parse(time.RFC3339, "2023-04-18T23:22:21Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, tree := interruptExtract(t, dumpPath, dumpDate, workdir)
+	cp, err := ReadCheckpoint(workdir, dumpDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp.Position.Offset += 1
+	if err := cp.Write(workdir, dumpDate); err != nil {
+		t.Fatal(err)
+	}
+
+	ex, err := NewExtractor(config, dumpPath, dumpDate, workdir, tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log bytes.Buffer
+	ex.Log = &log
+	if err := ex.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(log.String(), "reading dump from the start: ") {
+		t.Errorf("expected run to read dump from start, got log %q", log.String())
+	}
+	checkExtracts(t, workdir)
+}
+
+// interruptExtract runs an extraction that gets interrupted right
+// after its checkpoint at the second entity.
+func interruptExtract(t *testing.T, dumpPath string, dumpDate time.Time, workdir string) (*Config, Subclasser) {
+	t.Helper()
+	tree, err := ReadClassTree(filepath.Join("testdata", "classes", "entities.json.bz2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ReadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ex, err := NewExtractor(config, dumpPath, dumpDate, workdir, tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ex.CheckpointInterval = 2
+	ex.Log = &cancelingWriter{"checkpoint after 2 entities", cancel}
+	if err := ex.Run(ctx); err == nil {
+		t.Fatal("expected interrupted run to fail")
+	}
+	return config, tree
+}
+
+// cancelingWriter cancels a context once a message gets written to it.
+type cancelingWriter struct {
+	message string
+	cancel  context.CancelFunc
+}
+
+func (w *cancelingWriter) Write(p []byte) (int, error) {
+	if strings.Contains(string(p), w.message) {
+		w.cancel()
+	}
+	return len(p), nil
+}
+
+func TestFormatProgress(t *testing.T) {
+	p := x.Progress{Count: 50e6, Size: 200e6, Elapsed: 10 * time.Second}
+	got := formatProgress(p, 120000)
+	want := "25.0% done, 120000 entities, 12000 entities/s, 5.0 MB/s, 0s remaining"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	p.Elapsed = 0
+	got = formatProgress(p, 0)
+	want = "25.0% done, 0 entities"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// fullDumpDate is the date of the dump in testdata/full.
+var fullDumpDate = time.Date(2023, 4, 18, 23, 22, 21, 0, time.UTC)
+
+// runFullExtract runs the extractor with the built-in configuration
+// on the dump in testdata/full, and returns the manifest of the run.
+// If configure is not nil, it gets called before the extractor runs.
+func runFullExtract(t *testing.T, workdir string, configure func(*Extractor)) *Manifest {
+	t.Helper()
+	dumpPath := filepath.Join("testdata", "full", "entities.json.bz2")
+	tree, err := ReadClassTree(filepath.Join("testdata", "classes", "entities.json.bz2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := ReadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ex, err := NewExtractor(config, dumpPath, fullDumpDate, workdir, tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ex.Log = io.Discard
+	if configure != nil {
+		configure(ex)
+	}
+	if err := ex.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	m, err := ReadManifest(workdir, fullDumpDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// Compares the extracts in workdir to the expected output in testdata.
+func checkExtracts(t *testing.T, workdir string) {
+	for _, f := range []string{"givennames", "familynames"} {
+		gotPath := filepath.Join(workdir, fmt.Sprintf("%s-20230418.csv.gz", f))
+		got, err := readExtract(gotPath)
 		if err != nil {
 			t.Error(err)
 			return
 		}
-		got := string(gotBytes)
 
 		wantPath := filepath.Join("testdata", "full", fmt.Sprintf("want_%s.csv", f))
 		wantBytes, err := os.ReadFile(wantPath)
@@ -140,5 +486,274 @@ func TestExtractor(t *testing.T) {
 		if got != want {
 			t.Errorf("%s: got %v, want %v", f, got, want)
 		}
+
+		gotItems, err := readExtract(filepath.Join(workdir, fmt.Sprintf("%s-20230418.ndjson.gz", f)))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		wantItems, err := os.ReadFile(filepath.Join("testdata", "full", fmt.Sprintf("want_%s.ndjson", f)))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if gotItems != string(wantItems) {
+			t.Errorf("%s: got items %v, want %v", f, gotItems, string(wantItems))
+		}
+	}
+}
+
+// Returns the uncompressed content of a gzipped extract.
+func readExtract(path string) (string, error) {
+	stream, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+	gzStream, err := gzip.NewReader(stream)
+	if err != nil {
+		return "", err
+	}
+	gotBytes, err := io.ReadAll(gzStream)
+	if err != nil {
+		return "", err
+	}
+	return string(gotBytes), nil
+}
+
+func TestExtractorConfig(t *testing.T) {
+	workdir := t.TempDir()
+	dumpPath := filepath.Join("testdata", "full", "entities.json.bz2")
+	dumpDate, err := time.Parse(time.RFC3339, "2023-04-18T23:22:21Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configPath := filepath.Join(workdir, "config.json")
+	configJSON := `{"outputs": [{
+		"name": "femalenames",
+		"classes": ["Q202444"],
+		"exclude": ["Q12308941"],
+		"sources": ["label", "P1705"]
+	}]}`
+	if err := os.WriteFile(configPath, []byte(configJSON), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := ReadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := ReadClassTree(filepath.Join("testdata", "classes", "entities.json.bz2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ex, err := NewExtractor(config, dumpPath, dumpDate, workdir, tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ex.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readExtract(filepath.Join(workdir, "femalenames-20230418.csv.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(got), "\n")
+	if len(lines) < 2 {
+		t.Fatalf("got too few lines: %q", got)
+	}
+	for _, line := range lines[1:] {
+		n, err := NameFromRecord(strings.Split(line, ","))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n.ID != "Q167755" {
+			t.Errorf("expected only Astrid (Q167755), got %q", line)
+		}
+		if n.Source != "label" && n.Source != "P1705" {
+			t.Errorf("expected only labels and P1705, got %q", line)
+		}
+	}
+
+	if ok, err := ShouldRun(config, dumpDate, workdir); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Error("expected ShouldRun() to return false after run")
+	}
+}
+
+// An entity that is an instance of an excluded class stays out of
+// an output, even if it is also an instance of an included class
+// outside the subtree of the excluded one. In the dump for this test,
+// Sigrid (Q90000005) is a given name (Q202444), Ingrid (Q90000006)
+// is both a given name and a disambiguation page (Q4167410), and
+// Ivar (Q90000007) is a male given name (Q12308941).
+func TestExtractorExclude(t *testing.T) {
+	workdir := t.TempDir()
+	dumpPath := filepath.Join("testdata", "exclude", "entities.json.bz2")
+	dumpDate, err := time.Parse(time.RFC3339, "2023-04-18T23:22:21Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configPath := filepath.Join(workdir, "config.json")
+	configJSON := `{"outputs": [{
+		"name": "givennames",
+		"classes": ["Q202444"],
+		"exclude": ["Q12308941", "Q4167410"],
+		"sources": ["label"]
+	}]}`
+	if err := os.WriteFile(configPath, []byte(configJSON), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := ReadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := ReadClassTree(filepath.Join("testdata", "classes", "entities.json.bz2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ex, err := NewExtractor(config, dumpPath, dumpDate, workdir, tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ex.Log = io.Discard
+	if err := ex.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readExtract(filepath.Join(workdir, "givennames-20230418.csv.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join(NameHeader, ",") + "\n" +
+		"Sigrid,Q90000005,en,label,Latn,,Q202444,sigrid,0\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// A corrupt record in the spool of names must fail the run,
+// not turn into an empty row of the extract.
+func TestOutputCloseBadSpool(t *testing.T) {
+	workdir := t.TempDir()
+	dumpDate, err := time.Parse(time.RFC3339, "2023-04-18T23:22:21Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := ReadClassTree(filepath.Join("testdata", "classes", "entities.json.bz2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &OutputConfig{Name: "givennames", Classes: []string{"Q202444"}, Sources: []string{"label"}}
+	o, err := NewOutput(dumpDate, workdir, config, nil, tree, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.RemoveSpools()
+
+	if err := o.WriteNames([]Name{{Name: "Astrid", ID: "Q167755"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.names.Write([]byte("Ivar\x00Q127069")); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Close(); err == nil {
+		t.Error("expected error for corrupt spool record")
+	}
+	if _, err := os.Stat(extractPath(workdir, "givennames", dumpDate)); !os.IsNotExist(err) {
+		t.Errorf("expected no extract after failure, got %v", err)
+	}
+}
+
+func TestEntityNames(t *testing.T) {
+	e := mediawiki.Entity{
+		ID: "Q66147",
+		Labels: map[string]mediawiki.LanguageValue{
+			"de": {Language: "de", Value: "Meier"},
+			"en": {Language: "en", Value: "Meier"},
+			"ru": {Language: "ru", Value: "Майер"},
+		},
+		Aliases: map[string][]mediawiki.LanguageValue{
+			"de": {{Language: "de", Value: "Maier"}, {Language: "de", Value: "Mayr"}, {Language: "de", Value: " Maier  "}},
+			"fr": {{Language: "fr", Value: "Maier"}},
+			"nl": {{Language: "nl", Value: "Meier"}, {Language: "nl", Value: " "}},
+		},
+		Claims: map[string][]mediawiki.Statement{
+			"P282": {claim(mediawiki.WikiBaseEntityIDValue{ID: "Q8229"})},
+			"P1705": {
+				claim(mediawiki.MonolingualTextValue{Language: "de", Text: "Meier"}),
+				claim(mediawiki.MonolingualTextValue{Language: "gsw", Text: "Meier"}),
+			},
+			"P1814": {claim(mediawiki.StringValue("マイアー"))},
+			"P2440": {claim(mediawiki.StringValue("Majer"))},
+		},
+	}
+
+	names, rejections := EntityNames(&e, []string{"label", "alias", "P1705", "P1814", "P2440"}, nil)
+	if len(rejections) != 0 {
+		t.Errorf("got rejections %v without a filter", rejections)
+	}
+	gotVec := make([]string, 0, len(names))
+	for _, n := range names {
+		s := fmt.Sprintf("%s/%s/%s/%s/%s/%s/%s", n.Name, n.ID, n.Source,
+			strings.Join(n.Languages, ";"), strings.Join(n.Scripts, ";"),
+			strings.Join(n.WritingSystems, ";"), n.Folded)
+		gotVec = append(gotVec, s)
+	}
+	sort.Strings(gotVec)
+	got := strings.Join(gotVec, " ")
+	want := ("Maier/Q66147/alias/de;fr/Latn/Q8229/maier " +
+		"Majer/Q66147/P2440//Latn/Q8229/majer " +
+		"Mayr/Q66147/alias/de/Latn/Q8229/mayr " +
+		"Meier/Q66147/P1705/de;gsw/Latn/Q8229/meier " +
+		"Meier/Q66147/alias/nl/Latn/Q8229/meier " +
+		"Meier/Q66147/label/de;en/Latn/Q8229/meier " +
+		"Майер/Q66147/label/ru/Cyrl/Q8229/маиер " +
+		"マイアー/Q66147/P1814/ja/Kana/Q8229/マイアー")
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// Returns a statement whose main snak has the passed value.
+func claim(value interface{}) mediawiki.Statement {
+	return mediawiki.Statement{
+		Rank: mediawiki.Normal,
+		MainSnak: mediawiki.Snak{
+			SnakType:  mediawiki.Value,
+			DataValue: &mediawiki.DataValue{Value: value},
+		},
+	}
+}
+
+func TestMostSpecificClasses(t *testing.T) {
+	givenNames := ClassSet{202444: {}, 3409032: {}, 11879590: {}, 12308941: {}}
+	roots := ClassSet{202444: {}}
+	for _, tc := range []struct {
+		classes []int64
+		want    string
+	}{
+		{[]int64{202444}, "Q202444"},
+		{[]int64{11879590}, "Q11879590"},
+		{[]int64{202444, 11879590}, "Q11879590"},
+		{[]int64{12308941, 11879590, 5}, "Q11879590;Q12308941"},
+		{[]int64{5}, ""},
+	} {
+		entityClasses := make(ClassSet, len(tc.classes))
+		for _, c := range tc.classes {
+			entityClasses[c] = struct{}{}
+		}
+		got := strings.Join(MostSpecificClasses(entityClasses, givenNames, roots), ";")
+		if got != tc.want {
+			t.Errorf("got %q, want %q, classes=%v", got, tc.want, tc.classes)
+		}
 	}
 }
@@ -0,0 +1,210 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// FilterConfig tells how to treat names that are matched by a quality
+// rule, such as "digits". The Action is either "reject", which drops
+// the name from the extract, or "repair", which cleans it up.
+// Only some rules can repair names.
+type FilterConfig struct {
+	Rule   string `json:"rule"`
+	Action string `json:"action"`
+}
+
+// filterRule recognizes strings that are not plain names. If repair
+// is not nil, it returns the name with the offending part removed.
+type filterRule struct {
+	match  func(name string) bool
+	repair func(name string) string
+}
+
+var (
+	parentheticalPattern = regexp.MustCompile(`\s*[(（][^()（）]*[)）]$`)
+	qidPattern           = regexp.MustCompile(`(?i)^[LPQ][1-9]\d*$`)
+	urlPattern           = regexp.MustCompile(`(?i)([a-z]+://|\bwww\.)`)
+)
+
+// The rules that can be listed in FilterConfig.Rule.
+var filterRules = map[string]filterRule{
+	// Disambiguation qualifiers, such as "Weiss (surname)".
+	"parenthetical": {
+		match:  parentheticalPattern.MatchString,
+		repair: func(name string) string { return parentheticalPattern.ReplaceAllString(name, "") },
+	},
+
+	// Labels that are just a Wikidata ID, typically the item's own.
+	"qid": {match: qidPattern.MatchString},
+
+	"digits": {match: func(name string) bool { return strings.IndexFunc(name, unicode.IsDigit) >= 0 }},
+	"url":    {match: urlPattern.MatchString},
+	"emoji":  {match: func(name string) bool { return strings.IndexFunc(name, isEmoji) >= 0 }},
+}
+
+// emoji approximates the Unicode property Extended_Pictographic,
+// plus the variation selector that requests emoji presentation.
+var emoji = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0x2600, Hi: 0x27bf, Stride: 1},
+		{Lo: 0xfe0f, Hi: 0xfe0f, Stride: 1},
+	},
+	R32: []unicode.Range32{
+		{Lo: 0x1f000, Hi: 0x1faff, Stride: 1},
+	},
+}
+
+func isEmoji(c rune) bool {
+	return unicode.Is(emoji, c)
+}
+
+// Filter applies the configured quality rules to names.
+// A nil *Filter accepts all names unchanged.
+type Filter struct {
+	rules  []filterRule
+	names  []string
+	repair []bool
+}
+
+// NewFilter returns a filter for rules that have already been checked
+// by Config.validate(). If no rules are configured, the result is nil.
+func NewFilter(configs []FilterConfig) *Filter {
+	if len(configs) == 0 {
+		return nil
+	}
+	f := &Filter{}
+	for _, c := range configs {
+		f.rules = append(f.rules, filterRules[c.Rule])
+		f.names = append(f.names, c.Rule)
+		f.repair = append(f.repair, c.Action == "repair")
+	}
+	return f
+}
+
+// Apply checks a normalized name against the rules, in the order of
+// the configuration. It returns the repaired name, or an empty name
+// and the rule by which the name got rejected.
+func (f *Filter) Apply(name string) (string, string) {
+	if f == nil {
+		return name, ""
+	}
+	for i, rule := range f.rules {
+		if !rule.match(name) {
+			continue
+		}
+		if !f.repair[i] {
+			return "", f.names[i]
+		}
+		name = NormalizeName(rule.repair(name))
+		if name == "" {
+			return "", f.names[i]
+		}
+	}
+	return name, ""
+}
+
+// Rejection is a name that got dropped by a Filter, kept for auditing
+// the filter rules. Name is the spelling before any repairs, and
+// Reason is the rule that rejected it.
+type Rejection struct {
+	Name      string
+	ID        string
+	Languages []string
+	Source    string
+	Reason    string
+}
+
+// RejectionHeader is the CSV header of the rejected names file.
+// Its first column tells the output the name would have gone into.
+var RejectionHeader = []string{"Output", "Name", "WikidataID", "Languages", "Source", "Reason"}
+
+func (r *Rejection) ToBytes() []byte {
+	return []byte(strings.Join([]string{
+		r.Name, r.ID, strings.Join(r.Languages, ";"), r.Source, r.Reason,
+	}, "\x00"))
+}
+
+func RejectionFromBytes(b []byte) (Rejection, error) {
+	r := strings.Split(string(b), "\x00")
+	if len(r) != 5 {
+		return Rejection{}, fmt.Errorf("expected 5 fields, got %d", len(r))
+	}
+	return Rejection{Name: r[0], ID: r[1], Languages: splitList(r[2]), Source: r[3], Reason: r[4]}, nil
+}
+
+// compareRejections orders rejected names like the names in the
+// extracts, by spelling and numeric Wikidata ID, then by source.
+func compareRejections(a, b *Rejection) int {
+	an, bn := Name{Name: a.Name, ID: a.ID}, Name{Name: b.Name, ID: b.ID}
+	if c := compareNameKeys(&an, &bn); c != 0 {
+		return c
+	}
+	if c := strings.Compare(a.Source, b.Source); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Reason, b.Reason)
+}
+
+// rejectedPath returns the path of the rejected names file,
+// such as "rejected-20230418.csv.gz" in the working directory.
+func rejectedPath(workdir string, date time.Time) string {
+	return extractPath(workdir, rejectedName, date)
+}
+
+// The name of the rejected names file, which is therefore
+// not available as the name of an output.
+const rejectedName = "rejected"
+
+// WriteRejections writes the names that got rejected for any of
+// the outputs into a gzipped CSV file, sorted by output and name.
+// Rejections are rare enough to be sorted in memory.
+func WriteRejections(path string, outputs []*Output) (int64, error) {
+	w, err := newCSVFileWriter(path, RejectionHeader)
+	if err != nil {
+		return 0, err
+	}
+	defer w.Abort()
+
+	var count int64
+	for _, o := range outputs {
+		var rejections []Rejection
+		err := o.rejected.ReadAll(func(record []byte) error {
+			r, err := RejectionFromBytes(record)
+			if err != nil {
+				return err
+			}
+			rejections = append(rejections, r)
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		slices.SortFunc(rejections, func(a, b Rejection) int {
+			return cmp.Or(compareRejections(&a, &b),
+				strings.Compare(strings.Join(a.Languages, ";"), strings.Join(b.Languages, ";")))
+		})
+		for _, r := range rejections {
+			err := w.Write([]string{
+				o.name, r.Name, r.ID, strings.Join(r.Languages, ";"), r.Source, r.Reason,
+			})
+			if err != nil {
+				return 0, err
+			}
+		}
+		count += int64(len(rejections))
+	}
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
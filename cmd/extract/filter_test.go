@@ -0,0 +1,146 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+func TestFilter(t *testing.T) {
+	config, err := ReadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := NewFilter(config.Filters)
+	for _, tc := range []struct {
+		name, want, reason string
+	}{
+		{"Weiss", "Weiss", ""},
+		{"Weiss (surname)", "Weiss", ""},
+		{"Weiss  (apellido)", "Weiss", ""},
+		{"Weiss（苗字）", "Weiss", ""},
+		{"(surname)", "", "parenthetical"},
+		{"Anna (Maria) Weiss", "Anna (Maria) Weiss", ""},
+		{"Q145210", "", "qid"},
+		{"q42", "", "qid"},
+		{"Qadir", "Qadir", ""},
+		{"Louis XIV", "Louis XIV", ""},
+		{"Louis 14", "", "digits"},
+		{"Weiss (1884)", "Weiss", ""},
+		{"٣", "", "digits"},
+		{"https://example.org/Weiss", "", "url"},
+		{"www.weiss.de", "", "url"},
+		{"Weiss 😀", "", "emoji"},
+		{"Anna ♥", "", "emoji"},
+	} {
+		got, reason := filter.Apply(tc.name)
+		if got != tc.want || reason != tc.reason {
+			t.Errorf("Apply(%q): got %q %q, want %q %q", tc.name, got, reason, tc.want, tc.reason)
+		}
+	}
+
+	var none *Filter
+	if got, reason := none.Apply("Q1"); got != "Q1" || reason != "" {
+		t.Errorf("nil filter: got %q %q", got, reason)
+	}
+}
+
+func TestFilterReject(t *testing.T) {
+	filter := NewFilter([]FilterConfig{{Rule: "parenthetical", Action: "reject"}})
+	if got, reason := filter.Apply("Weiss (surname)"); got != "" || reason != "parenthetical" {
+		t.Errorf("got %q %q", got, reason)
+	}
+	if NewFilter(nil) != nil {
+		t.Error("expected nil filter without rules")
+	}
+}
+
+func TestEntityNamesFilter(t *testing.T) {
+	e := mediawiki.Entity{
+		ID: "Q145210",
+		Labels: map[string]mediawiki.LanguageValue{
+			"de": {Language: "de", Value: "Weiss"},
+			"ja": {Language: "ja", Value: "Q145210"},
+			"ko": {Language: "ko", Value: "Q145210"},
+		},
+		Aliases: map[string][]mediawiki.LanguageValue{
+			"es": {{Language: "es", Value: "Weiss (apellido)"}},
+			"en": {{Language: "en", Value: "Weiss2"}},
+		},
+	}
+	config, err := ReadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, rejections := EntityNames(&e, []string{"label", "alias"}, NewFilter(config.Filters))
+
+	var got []string
+	for _, n := range names {
+		got = append(got, fmt.Sprintf("%s/%s/%s", n.Name, n.Source, strings.Join(n.Languages, ";")))
+	}
+	for _, r := range rejections {
+		got = append(got, fmt.Sprintf("%s/%s/%s/%s", r.Name, r.Source, strings.Join(r.Languages, ";"), r.Reason))
+	}
+	sort.Strings(got)
+	want := "Q145210/label/ja;ko/qid Weiss/alias/es Weiss/label/de Weiss2/alias/en/digits"
+	if s := strings.Join(got, " "); s != want {
+		t.Errorf("got %q, want %q", s, want)
+	}
+}
+
+func TestWriteRejections(t *testing.T) {
+	dir := t.TempDir()
+	date := time.Date(2023, 4, 18, 0, 0, 0, 0, time.UTC)
+	var outputs []*Output
+	for _, name := range []string{"familynames", "givennames"} {
+		spool, err := OpenSpool(spoolPath(dir, name+"-rejected", date))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer spool.Close()
+		outputs = append(outputs, &Output{name: name, rejected: spool})
+	}
+
+	if err := outputs[1].WriteRejections([]Rejection{
+		{Name: "Q7", ID: "Q7", Languages: []string{"en"}, Source: "label", Reason: "qid"},
+		{Name: "Anna1", ID: "Q12", Languages: []string{"de", "fr"}, Source: "alias", Reason: "digits"},
+		{Name: "Anna1", ID: "Q3", Source: "P1705", Reason: "digits"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := outputs[0].WriteRejections([]Rejection{
+		{Name: "Weiss ♥", ID: "Q145210", Languages: []string{"en"}, Source: "alias", Reason: "emoji"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := rejectedPath(dir, date)
+	count, err := WriteRejections(path, outputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 4 || filepath.Base(path) != "rejected-20230418.csv.gz" {
+		t.Errorf("got %d rejections in %s", count, path)
+	}
+
+	got, err := readExtract(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Output,Name,WikidataID,Languages,Source,Reason\n" +
+		"familynames,Weiss ♥,Q145210,en,alias,emoji\n" +
+		"givennames,Anna1,Q3,,P1705,digits\n" +
+		"givennames,Anna1,Q12,de;fr,alias,digits\n" +
+		"givennames,Q7,Q7,en,label,qid\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+// givenNameProperty is the "given name" property. Only the bearers of
+// given names get counted by sex or gender; family names are passed on
+// to children of any gender, so such counts would tell nothing.
+const givenNameProperty = "P735"
+
+// gender is the sex or gender (P21) of a name bearer, as spooled
+// in the bearer records. Humans without a P21 claim are unknown,
+// and do not count towards any gender.
+type gender byte
+
+const (
+	genderUnknown gender = iota
+	genderMale
+	genderFemale
+	genderOther
+)
+
+// genderOf returns the sex or gender (P21) of a human. Trans women
+// and trans men count as female and male, since we want to know how
+// names are used. Any other value, and conflicting claims, count as
+// other.
+func genderOf(e *mediawiki.Entity) gender {
+	result := genderUnknown
+	for _, qid := range ItemClaims(e, "P21") {
+		var g gender
+		switch qid {
+		case 6581097, 2449503: // male, trans man
+			g = genderMale
+		case 6581072, 1052281: // female, trans woman
+			g = genderFemale
+		default:
+			g = genderOther
+		}
+		if result != genderUnknown && result != g {
+			return genderOther
+		}
+		result = g
+	}
+	return result
+}
+
+// genderCounts is the number of bearers of a name by sex or gender.
+type genderCounts struct {
+	male, female, other int64
+}
+
+// add counts one more bearer of gender g.
+func (c *genderCounts) add(g gender) {
+	switch g {
+	case genderMale:
+		c.male += 1
+	case genderFemale:
+		c.female += 1
+	case genderOther:
+		c.other += 1
+	}
+}
+
+// GendersHeader is the CSV header of the name genders file.
+var GendersHeader = []string{"WikidataID", "Male", "Female", "Other"}
+
+// gendersPath returns the path of the name genders file,
+// such as "name-genders-20230418.csv.gz" in the working directory.
+func gendersPath(workdir string, date time.Time) string {
+	return extractPath(workdir, "name-genders", date)
+}
+
+// WriteGenders writes how many bearers of each given name are male,
+// female or of another sex or gender into a gzipped CSV file, sorted
+// by numeric name ID. Outputs for other kinds of names have no gender
+// counts. If several outputs count given names, an item that is a
+// name in more than one of them gets the sum of its counts.
+func WriteGenders(path string, outputs []*Output) (int64, error) {
+	counts := make(map[int64]genderCounts, 100000)
+	for _, o := range outputs {
+		for qid, c := range o.genders {
+			sum := counts[qid]
+			sum.male += c.male
+			sum.female += c.female
+			sum.other += c.other
+			counts[qid] = sum
+		}
+	}
+	keys := make([]int64, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	w, err := newCSVFileWriter(path, GendersHeader)
+	if err != nil {
+		return 0, err
+	}
+	defer w.Abort()
+	for _, k := range keys {
+		c := counts[k]
+		err := w.Write([]string{
+			fmt.Sprintf("Q%d", k),
+			strconv.FormatInt(c.male, 10),
+			strconv.FormatInt(c.female, 10),
+			strconv.FormatInt(c.other, 10),
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+	return int64(len(keys)), nil
+}
@@ -0,0 +1,83 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+func TestGenderOf(t *testing.T) {
+	for _, tc := range []struct {
+		claims []string
+		want   gender
+	}{
+		{nil, genderUnknown},
+		{[]string{"Q6581097"}, genderMale},
+		{[]string{"Q6581072"}, genderFemale},
+		{[]string{"Q1052281"}, genderFemale},
+		{[]string{"Q2449503"}, genderMale},
+		{[]string{"Q48270"}, genderOther},
+		{[]string{"Q6581072", "Q1052281"}, genderFemale},
+		{[]string{"Q6581097", "Q6581072"}, genderOther},
+	} {
+		var statements []mediawiki.Statement
+		for _, c := range tc.claims {
+			statements = append(statements, claim(mediawiki.WikiBaseEntityIDValue{ID: c}))
+		}
+		e := mediawiki.Entity{Claims: map[string][]mediawiki.Statement{"P21": statements}}
+		if got := genderOf(&e); got != tc.want {
+			t.Errorf("%v: got %d, want %d", tc.claims, got, tc.want)
+		}
+	}
+}
+
+func TestWriteGenders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "name-genders-20230418.csv.gz")
+	outputs := []*Output{
+		{genders: map[int64]genderCounts{7: {male: 2, other: 1}, 1000: {female: 4}}},
+		{genders: map[int64]genderCounts{7: {male: 1, female: 3}}},
+	}
+	count, err := WriteGenders(path, outputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("got count %d, want 2", count)
+	}
+
+	got, err := readExtract(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "WikidataID,Male,Female,Other\n" +
+		"Q7,3,3,1\n" +
+		"Q1000,0,4,0\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestExtractorGenders(t *testing.T) {
+	workdir := t.TempDir()
+	m := runFullExtract(t, workdir, nil)
+
+	// Astrid is also borne by a fictional female, who does not count.
+	// Family names, such as Weiss (Q145210), are not counted by gender.
+	got, err := readExtract(gendersPath(workdir, fullDumpDate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "WikidataID,Male,Female,Other\n" +
+		"Q127069,1,1,1\n" +
+		"Q167755,0,1,0\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if m.Genders != 2 || m.GendersFile == nil || m.GendersFile.Name != "name-genders-20230418.csv.gz" {
+		t.Errorf("got %d genders in %v", m.Genders, m.GendersFile)
+	}
+}
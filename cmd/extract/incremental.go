@@ -0,0 +1,599 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+// IncrementalDump is a daily "adds-changes" dump of Wikidata,
+// which contains the revisions that were made since the day before.
+type IncrementalDump struct {
+	Date time.Time
+	Path string
+}
+
+// FindIncrementalDumps returns the completed incremental dumps
+// that are newer than a given date, oldest first.
+func FindIncrementalDumps(dumpsPath string, after time.Time) ([]IncrementalDump, error) {
+	dir := filepath.Join(dumpsPath, "other", "incr", "wikidatawiki")
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	result := make([]IncrementalDump, 0, len(entries))
+	for _, e := range entries {
+		date, err := time.Parse("20060102", e.Name())
+		if err != nil || !date.After(after) {
+			continue
+		}
+
+		// Wikimedia writes "done" into the status file once the
+		// dump is complete; until then, it is still being generated.
+		status, err := os.ReadFile(filepath.Join(dir, e.Name(), "status.txt"))
+		if err != nil || !strings.HasPrefix(string(status), "done") {
+			continue
+		}
+
+		name := fmt.Sprintf("wikidatawiki-%s-pages-meta-hist-incr.xml.bz2", e.Name())
+		path := filepath.Join(dir, e.Name(), name)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		result = append(result, IncrementalDump{Date: date, Path: path})
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Date.Before(result[j].Date) })
+	return result, nil
+}
+
+// errNoPreviousExtract tells that a working directory contains
+// no complete extract from before the given date.
+var errNoPreviousExtract = errors.New("no previous extract")
+
+var extractNamePattern = regexp.MustCompile(`^(.+)-(\d{8})\.csv\.gz$`)
+
+// FindLatestExtract returns the date of the most recent extract
+// in workdir, weekly or incremental, for which all outputs exist.
+func FindLatestExtract(config *Config, workdir string) (time.Time, error) {
+	return FindPreviousExtract(config, workdir, time.Time{})
+}
+
+// FindPreviousExtract returns the date of the most recent complete
+// extract in workdir that is older than a given date. If the date
+// is zero, any extract qualifies.
+func FindPreviousExtract(config *Config, workdir string, before time.Time) (time.Time, error) {
+	beforeDay := before.Format("20060102")
+	entries, err := os.ReadDir(workdir)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	found := make(map[string]int, 10)
+	for _, e := range entries {
+		if m := extractNamePattern.FindStringSubmatch(e.Name()); m != nil {
+			found[m[2]] += 1
+		}
+	}
+
+	days := make([]string, 0, len(found))
+	for day, n := range found {
+		if !before.IsZero() && day >= beforeDay {
+			continue
+		}
+		if n >= len(config.Outputs) {
+			days = append(days, day)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(days)))
+
+	for _, day := range days {
+		date, err := time.Parse("20060102", day)
+		if err != nil {
+			continue
+		}
+		complete := true
+		for _, o := range config.Outputs {
+			if _, err := os.Stat(extractPath(workdir, o.Name, date)); err != nil {
+				complete = false
+				break
+			}
+		}
+		if complete {
+			return date, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("%w in %s", errNoPreviousExtract, workdir)
+}
+
+// Updater brings an existing extract up to date by applying the
+// revisions in incremental dumps. For every entity that got changed,
+// the rows of the old extract are replaced by the names of the entity's
+// latest revision. Entities that have become redirects, or whose latest
+// revision got deleted, lose their rows. The incremental dumps do not
+// tell about deleted pages, so their rows remain until the next full
+// extract.
+//
+// Only full runs write the files that need a pass over the entire
+// dump, such as those on the countries of name bearers or on persons,
+// and those listing the names that got added or removed. The manifest
+// that Run writes tells which full run these files come from.
+type Updater struct {
+	config     *Config
+	baseDate   time.Time
+	dumps      []IncrementalDump
+	workdir    string
+	subclasser Subclasser
+
+	// Log receives progress reports.
+	Log io.Writer
+}
+
+func NewUpdater(config *Config, baseDate time.Time, dumps []IncrementalDump, workdir string, subclasser Subclasser) (*Updater, error) {
+	if len(dumps) == 0 {
+		return nil, fmt.Errorf("no incremental dumps after %s", baseDate.Format("2006-01-02"))
+	}
+	return &Updater{
+		config:     config,
+		baseDate:   baseDate,
+		dumps:      dumps,
+		workdir:    workdir,
+		subclasser: subclasser,
+		Log:        os.Stderr,
+	}, nil
+}
+
+// Date returns the date of the extract that Run produces, which
+// is the date of the newest incremental dump.
+func (u *Updater) Date() time.Time {
+	return u.dumps[len(u.dumps)-1].Date
+}
+
+func (u *Updater) Run() error {
+	type target struct {
+		rootClasses     ClassSet
+		wikidataClasses ClassSet
+		excludedClasses ClassSet
+		sources         []string
+		bearers         string
+	}
+	started := time.Now()
+	setSubclasserLog(u.subclasser, u.Log)
+	filter := NewFilter(u.config.Filters)
+	targets := make([]target, 0, len(u.config.Outputs))
+	for i := range u.config.Outputs {
+		root, classes, excluded, err := ResolveClasses(&u.config.Outputs[i], u.subclasser)
+		if err != nil {
+			return err
+		}
+		o := &u.config.Outputs[i]
+		targets = append(targets, target{root, classes, excluded, o.Sources, o.Bearers})
+	}
+
+	// What every changed entity contributes to each output, indexed
+	// by output. Because dumps are read oldest first, later revisions
+	// replace earlier ones.
+	changed := make(map[string][]entityChange, 100000)
+	removed := make(map[string]bool, 1000)
+	for _, dump := range u.dumps {
+		fmt.Fprintf(u.Log, "reading %s\n", dump.Path)
+		err := ReadRevisions(dump.Path, func(id string, e *mediawiki.Entity) error {
+			changes := make([]entityChange, len(targets))
+			removed[id] = e == nil
+			if e != nil {
+				entityClasses := WikidataClasses(e)
+				_, isHuman := entityClasses[human]
+				for i, t := range targets {
+					if isHuman && t.bearers != "" {
+						qids := ItemClaims(e, t.bearers)
+						slices.Sort(qids)
+						changes[i].bearers = slices.Compact(qids)
+					}
+					if !entityClasses.ContainsAny(&t.wikidataClasses) || t.excludedClasses.ContainsAny(&entityClasses) {
+						continue
+					}
+					classes := MostSpecificClasses(entityClasses, t.wikidataClasses, t.rootClasses)
+					// Rejected names only get listed by full runs.
+					changes[i].names, _ = SelectNames(e, classes, t.sources, filter)
+					changes[i].item = NewItem(e, classes)
+				}
+			}
+			changed[id] = changes
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	manifest := &Manifest{
+		DumpDate:    u.Date().Format("2006-01-02"),
+		BaseDate:    u.baseDate.Format("2006-01-02"),
+		ToolVersion: toolVersion(),
+		Started:     started.UTC().Truncate(time.Second),
+		Outputs:     make([]ManifestOutput, 0, len(u.config.Outputs)),
+	}
+	for _, dump := range u.dumps {
+		manifest.IncrementalPaths = append(manifest.IncrementalPaths, dump.Path)
+	}
+	// When updating the result of an earlier update, the files
+	// that only full runs write are still those of its base.
+	if base, err := ReadManifest(u.workdir, u.baseDate); err == nil {
+		manifest.DumpPath = base.DumpPath
+		if base.BaseDate != "" {
+			manifest.BaseDate = base.BaseDate
+		}
+	} else if !os.IsNotExist(err) {
+		fmt.Fprintf(u.Log, "cannot read manifest of base extract: %v\n", err)
+	}
+
+	for i := range u.config.Outputs {
+		o := &u.config.Outputs[i]
+		var files []string
+		for _, format := range o.Formats {
+			if format == "ndjson" {
+				if err := u.updateItems(o, changed, i); err != nil {
+					return err
+				}
+				files = append(files, itemsPath(u.workdir, o.Name, u.Date()))
+			}
+		}
+		basePath := extractPath(u.workdir, o.Name, u.baseDate)
+		m, err := u.update(basePath, o, changed, i)
+		if err != nil {
+			return err
+		}
+		m.RootClasses = formatClasses(targets[i].rootClasses)
+		m.Classes = formatClasses(targets[i].wikidataClasses)
+		for _, path := range files {
+			f, err := manifestFile(path)
+			if err != nil {
+				return err
+			}
+			m.Files = append(m.Files, f)
+		}
+		manifest.Outputs = append(manifest.Outputs, m)
+	}
+	manifest.Duration = time.Since(started).Round(time.Second).Seconds()
+	if err := manifest.Write(u.workdir, u.Date()); err != nil {
+		return err
+	}
+
+	numRemoved := 0
+	for _, r := range removed {
+		if r {
+			numRemoved++
+		}
+	}
+	fmt.Fprintf(u.Log, "applied %d changed entities, %d of them redirected or deleted, from %d incremental dumps\n",
+		len(changed), numRemoved, len(u.dumps))
+	return nil
+}
+
+// update writes a new extract, taking the rows of the extract
+// at basePath for all entities that did not change, plus the new names
+// of the entities that did, and describes the written files for the
+// manifest. Counting the bearers of names takes a pass over all humans,
+// so the counts are carried over from the base extract. Items that
+// are new to the output get the number of changed humans that refer
+// to them; a human needs to be edited to refer to a newly created
+// item, but not to an existing item that only now joined the output,
+// whose count may thus be too low until the next full run.
+func (u *Updater) update(basePath string, config *OutputConfig, changed map[string][]entityChange, output int) (ManifestOutput, error) {
+	base, err := os.Open(basePath)
+	if err != nil {
+		return ManifestOutput{}, err
+	}
+	defer base.Close()
+
+	decompressor, err := gzip.NewReader(base)
+	if err != nil {
+		return ManifestOutput{}, err
+	}
+	defer decompressor.Close()
+
+	reader := csv.NewReader(decompressor)
+	header, err := reader.Read()
+	if err != nil {
+		return ManifestOutput{}, fmt.Errorf("%s: %v", basePath, err)
+	}
+	if strings.Join(header, ",") != strings.Join(NameHeader, ",") {
+		return ManifestOutput{}, fmt.Errorf("%s: unexpected header %q", basePath, header)
+	}
+
+	writer, err := NewExtractWriter(u.workdir, config, u.Date(), 0)
+	if err != nil {
+		return ManifestOutput{}, err
+	}
+
+	bearers := make(map[string]int64, 100000)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			writer.Abort()
+			return ManifestOutput{}, fmt.Errorf("%s: %v", basePath, err)
+		}
+		n, err := NameFromRecord(record)
+		if err != nil {
+			writer.Abort()
+			return ManifestOutput{}, fmt.Errorf("%s: %v", basePath, err)
+		}
+		bearers[n.ID] = n.Bearers
+		if _, ok := changed[n.ID]; ok {
+			continue
+		}
+		if err := writer.WriteName(&n); err != nil {
+			writer.Abort()
+			return ManifestOutput{}, err
+		}
+	}
+
+	newBearers := make(map[string]int64, 1000)
+	for _, changes := range changed {
+		for _, qid := range changes[output].bearers {
+			newBearers[fmt.Sprintf("Q%d", qid)] += 1
+		}
+	}
+	for _, changes := range changed {
+		names := changes[output].names
+		for i := range names {
+			if n, ok := bearers[names[i].ID]; ok {
+				names[i].Bearers = n
+			} else {
+				names[i].Bearers = newBearers[names[i].ID]
+			}
+			if err := writer.WriteName(&names[i]); err != nil {
+				writer.Abort()
+				return ManifestOutput{}, err
+			}
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return ManifestOutput{}, err
+	}
+
+	m := ManifestOutput{Name: config.Name}
+	m.Rows, m.Items = writer.Counts()
+	for _, path := range writer.Paths() {
+		f, err := manifestFile(path)
+		if err != nil {
+			return ManifestOutput{}, err
+		}
+		m.Files = append(m.Files, f)
+	}
+	return m, nil
+}
+
+// updateItems writes a new NDJSON file for an output, taking the lines
+// of the base extract for all entities that did not change, plus the
+// new items for the entities that did.
+func (u *Updater) updateItems(config *OutputConfig, changed map[string][]entityChange, output int) error {
+	basePath := itemsPath(u.workdir, config.Name, u.baseDate)
+	base, err := os.Open(basePath)
+	if err != nil {
+		return err
+	}
+	defer base.Close()
+
+	decompressor, err := gzip.NewReader(base)
+	if err != nil {
+		return err
+	}
+	defer decompressor.Close()
+
+	writer, err := NewItemWriter(itemsPath(u.workdir, config.Name, u.Date()))
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(decompressor)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		l, err := parseItemLine(bytes.Clone(scanner.Bytes()))
+		if err != nil {
+			writer.Abort()
+			return fmt.Errorf("%s: %v", basePath, err)
+		}
+		if _, ok := changed[fmt.Sprintf("Q%d", l.qid)]; ok {
+			continue
+		}
+		if err := writer.write(l); err != nil {
+			writer.Abort()
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		writer.Abort()
+		return fmt.Errorf("%s: %v", basePath, err)
+	}
+
+	for _, changes := range changed {
+		if item := changes[output].item; item != nil {
+			if err := writer.WriteItem(item); err != nil {
+				writer.Abort()
+				return err
+			}
+		}
+	}
+
+	return writer.Close()
+}
+
+// entityChange is what a changed entity contributes to an output.
+// Names and item are empty if the entity no longer belongs to the
+// output. For humans, bearers lists the items that the human refers
+// to with the bearers property of the output, such as "given name"
+// (P735).
+type entityChange struct {
+	names   []Name
+	item    *Item
+	bearers []int64
+}
+
+// ReadRevisions calls a function for every item in an incremental
+// XML dump, passing the item's latest revision. For items that have
+// become redirects, or whose latest revision got deleted, the passed
+// entity is nil.
+func ReadRevisions(path string, fn func(id string, e *mediawiki.Entity) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := readRevisions(bzip2.NewReader(file), fn); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func readRevisions(r io.Reader, fn func(id string, e *mediawiki.Entity) error) error {
+	decoder := xml.NewDecoder(r)
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "page" {
+			continue
+		}
+
+		var page xmlPage
+		if err := decoder.DecodeElement(&page, &start); err != nil {
+			return err
+		}
+
+		// Namespace 0 holds items; properties and lexemes
+		// live in other namespaces.
+		if page.NS != 0 {
+			continue
+		}
+
+		// Only the latest revision counts. When its text has been
+		// deleted, we must not fall back to an earlier revision,
+		// whose names may be the reason for the deletion.
+		var rev *xmlRevision
+		for i := len(page.Revisions) - 1; i >= 0; i-- {
+			if page.Revisions[i].Model == "wikibase-item" {
+				rev = &page.Revisions[i]
+				break
+			}
+		}
+		if rev == nil {
+			continue
+		}
+
+		var e *mediawiki.Entity
+		if page.Redirect == nil && rev.Text.Deleted == "" {
+			if rev.Text.Value == "" {
+				continue
+			}
+			e, err = decodeRevision([]byte(rev.Text.Value))
+			if err != nil {
+				return fmt.Errorf("%s: %v", page.Title, err)
+			}
+		}
+		if err := fn(page.Title, e); err != nil {
+			return err
+		}
+	}
+}
+
+type xmlPage struct {
+	Title     string        `xml:"title"`
+	NS        int           `xml:"ns"`
+	Redirect  *struct{}     `xml:"redirect"`
+	Revisions []xmlRevision `xml:"revision"`
+}
+
+type xmlRevision struct {
+	Model string `xml:"model"`
+	Text  struct {
+		Value   string `xml:",chardata"`
+		Deleted string `xml:"deleted,attr"`
+	} `xml:"text"`
+}
+
+// decodeRevision decodes the text of an item revision. In the XML
+// dumps, entities are serialized the way Wikibase stores them, which
+// differs from the JSON dumps: PHP writes empty maps as [], and
+// redirects look like {"entity":"Q1","redirect":"Q2"}. For redirects,
+// we return nil.
+func decodeRevision(text []byte) (*mediawiki.Entity, error) {
+	decoder := json.NewDecoder(bytes.NewReader(text))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	fields, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected JSON object")
+	}
+	if _, isRedirect := fields["redirect"]; isRedirect {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(dropEmptyLists(v))
+	if err != nil {
+		return nil, err
+	}
+
+	var e mediawiki.Entity
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// dropEmptyLists removes all object members whose value is [].
+// All fields of mediawiki.Entity that can be empty are optional,
+// so this is harmless for real lists and fixes empty maps.
+func dropEmptyLists(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, member := range val {
+			if list, ok := member.([]interface{}); ok && len(list) == 0 {
+				delete(val, k)
+			} else {
+				val[k] = dropEmptyLists(member)
+			}
+		}
+	case []interface{}:
+		for i, item := range val {
+			val[i] = dropEmptyLists(item)
+		}
+	}
+	return v
+}
@@ -0,0 +1,316 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+func TestFindIncrementalDumps(t *testing.T) {
+	dumpsDir := t.TempDir()
+	dir := filepath.Join(dumpsDir, "other", "incr", "wikidatawiki")
+	for day, status := range map[string]string{
+		"20250214": "done",
+		"20250215": "done",
+		"20250216": "done:all",
+		"20250217": "in-progress",
+	} {
+		if err := os.MkdirAll(filepath.Join(dir, day), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, day, "status.txt"), []byte(status), 0644); err != nil {
+			t.Fatal(err)
+		}
+		name := "wikidatawiki-" + day + "-pages-meta-hist-incr.xml.bz2"
+		if err := os.WriteFile(filepath.Join(dir, day, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	after, _ := time.Parse("20060102", "20250214")
+	got, err := FindIncrementalDumps(dumpsDir, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %v, want 2 dumps", got)
+	}
+	for i, day := range []string{"20250215", "20250216"} {
+		if d := got[i].Date.Format("20060102"); d != day {
+			t.Errorf("got[%d].Date = %s, want %s", i, d, day)
+		}
+		want := filepath.Join(dir, day, "wikidatawiki-"+day+"-pages-meta-hist-incr.xml.bz2")
+		if got[i].Path != want {
+			t.Errorf("got[%d].Path = %q, want %q", i, got[i].Path, want)
+		}
+	}
+
+	// No incremental dumps at all.
+	if got, err := FindIncrementalDumps(t.TempDir(), after); err != nil || len(got) != 0 {
+		t.Errorf("got %v %v, want no dumps", got, err)
+	}
+}
+
+func TestFindLatestExtract(t *testing.T) {
+	workdir := t.TempDir()
+	config, err := ReadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := FindLatestExtract(config, workdir); !errors.Is(err, errNoPreviousExtract) {
+		t.Errorf("got %v for empty workdir, want errNoPreviousExtract", err)
+	}
+
+	for _, f := range []string{
+		"familynames-20230411.csv.gz",
+		"givennames-20230411.csv.gz",
+		"familynames-20230418.csv.gz",
+		"givennames-20230418.csv.gz",
+		"familynames-20230419.csv.gz",
+		"givennames-20230419.csv.gz.tmp",
+	} {
+		if err := os.WriteFile(filepath.Join(workdir, f), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := FindLatestExtract(config, workdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := got.Format("20060102"); d != "20230418" {
+		t.Errorf("got %s, want 20230418", d)
+	}
+}
+
+func TestDecodeRevision(t *testing.T) {
+	e, err := decodeRevision([]byte(`{"type":"item","id":"Q1","labels":[],"aliases":{"en":[{"language":"en","value":"Foo"}]},"claims":{"P31":[{"mainsnak":{"snaktype":"value","property":"P31","datavalue":{"value":{"entity-type":"item","numeric-id":5,"id":"Q5"},"type":"wikibase-entityid"}},"type":"statement","id":"Q1$1","rank":"normal","qualifiers":[]}]},"sitelinks":[]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.ID != "Q1" || len(e.Labels) != 0 || e.Aliases["en"][0].Value != "Foo" {
+		t.Errorf("got %v", e)
+	}
+	if got := formatClassSet(WikidataClasses(e)); got != "Q5" {
+		t.Errorf("got classes %q, want Q5", got)
+	}
+
+	e, err = decodeRevision([]byte(`{"entity":"Q1","redirect":"Q2"}`))
+	if e != nil || err != nil {
+		t.Errorf("got %v %v for redirect, want nil", e, err)
+	}
+}
+
+func TestReadRevisions(t *testing.T) {
+	dump := `<mediawiki>
+  <page>
+    <title>Q1</title>
+    <ns>0</ns>
+    <revision>
+      <model>wikibase-item</model>
+      <text>{"type":"item","id":"Q1","labels":{"en":{"language":"en","value":"Old"}}}</text>
+    </revision>
+    <revision>
+      <model>wikibase-item</model>
+      <text>{"type":"item","id":"Q1","labels":{"en":{"language":"en","value":"New"}}}</text>
+    </revision>
+  </page>
+  <page>
+    <title>Q2</title>
+    <ns>0</ns>
+    <revision>
+      <model>wikibase-item</model>
+      <text>{"type":"item","id":"Q2","labels":{"en":{"language":"en","value":"Hidden"}}}</text>
+    </revision>
+    <revision>
+      <model>wikibase-item</model>
+      <text deleted="deleted" />
+    </revision>
+  </page>
+  <page>
+    <title>Q3</title>
+    <ns>0</ns>
+    <redirect title="Q1" />
+    <revision>
+      <model>wikibase-item</model>
+      <text>{"entity":"Q3","redirect":"Q1"}</text>
+    </revision>
+  </page>
+  <page>
+    <title>Q4</title>
+    <ns>0</ns>
+    <redirect title="Q1" />
+    <revision>
+      <model>wikibase-item</model>
+      <text>{"type":"item","id":"Q4","labels":{"en":{"language":"en","value":"Merged"}}}</text>
+    </revision>
+  </page>
+  <page>
+    <title>Property:P31</title>
+    <ns>120</ns>
+    <revision>
+      <model>wikibase-property</model>
+      <text>{"type":"property","id":"P31"}</text>
+    </revision>
+  </page>
+</mediawiki>`
+
+	var got []string
+	err := readRevisions(strings.NewReader(dump), func(id string, e *mediawiki.Entity) error {
+		label := "<nil>"
+		if e != nil {
+			label = e.Labels["en"].Value
+		}
+		got = append(got, id+"="+label)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Q1=New Q2=<nil> Q3=<nil> Q4=<nil>"
+	if s := strings.Join(got, " "); s != want {
+		t.Errorf("got %q, want %q", s, want)
+	}
+}
+
+func TestUpdater(t *testing.T) {
+	workdir := t.TempDir()
+	dumpPath := filepath.Join("testdata", "full", "entities.json.bz2")
+	dumpDate, err := time.Parse(time.RFC3339, "2023-04-18T23:22:21Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := ReadClassTree(filepath.Join("testdata", "classes", "entities.json.bz2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ReadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ex, err := NewExtractor(config, dumpPath, dumpDate, workdir, tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ex.Log = io.Discard
+	if err := ex.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	baseDate, err := FindLatestExtract(config, workdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dumps, err := FindIncrementalDumps(filepath.Join("testdata", "dumps"), baseDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := NewUpdater(config, baseDate, dumps, workdir, tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Log = io.Discard
+	if err := u.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range []string{"givennames", "familynames"} {
+		got, err := readExtract(filepath.Join(workdir, f+"-20230419.csv.gz"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := os.ReadFile(filepath.Join("testdata", "dumps", "want_"+f+"-20230419.csv"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != string(want) {
+			t.Errorf("%s: got %v, want %v", f, got, string(want))
+		}
+
+		// The NDJSON output should have one line for every item
+		// in the CSV output, sorted by numeric ID.
+		var wantIDs []string
+		seen := make(map[string]bool)
+		records, err := csv.NewReader(strings.NewReader(got)).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, record := range records[1:] {
+			id := record[1]
+			if !seen[id] {
+				seen[id] = true
+				wantIDs = append(wantIDs, id)
+			}
+		}
+		sort.Slice(wantIDs, func(i, j int) bool {
+			a, _ := parseQID(wantIDs[i])
+			b, _ := parseQID(wantIDs[j])
+			return a < b
+		})
+		items, err := readExtract(filepath.Join(workdir, f+"-20230419.ndjson.gz"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var gotIDs []string
+		for _, line := range strings.Split(strings.TrimSpace(items), "\n") {
+			var item Item
+			if err := json.Unmarshal([]byte(line), &item); err != nil {
+				t.Fatal(err)
+			}
+			gotIDs = append(gotIDs, item.ID)
+		}
+		if !reflect.DeepEqual(gotIDs, wantIDs) {
+			t.Errorf("%s: got items %v, want %v", f, gotIDs, wantIDs)
+		}
+	}
+
+	// The manifest tells which full run the shared files come from.
+	m, err := ReadManifest(workdir, u.Date())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.DumpDate != "2023-04-19" || m.BaseDate != "2023-04-18" || m.DumpPath != dumpPath || len(m.IncrementalPaths) != 1 {
+		t.Errorf("got manifest for %s based on %s from %s and %v",
+			m.DumpDate, m.BaseDate, m.DumpPath, m.IncrementalPaths)
+	}
+	var files []string
+	for _, o := range m.Outputs {
+		for _, f := range o.Files {
+			files = append(files, f.Name)
+		}
+		if o.Rows == 0 || o.Items == 0 || len(o.Classes) == 0 {
+			t.Errorf("%s: got %d rows, %d items, classes %v", o.Name, o.Rows, o.Items, o.Classes)
+		}
+	}
+	sort.Strings(files)
+	wantFiles := "familynames-20230419.csv.gz familynames-20230419.ndjson.gz " +
+		"familynames-20230419.parquet familynames-20230419.sqlite " +
+		"givennames-20230419.csv.gz givennames-20230419.ndjson.gz " +
+		"givennames-20230419.parquet givennames-20230419.sqlite"
+	if got := strings.Join(files, " "); got != wantFiles {
+		t.Errorf("got manifest files %q, want %q", got, wantFiles)
+	}
+
+	if _, err := os.Stat(filepath.Join(workdir, "givennames-20230419.csv.gz.tmp")); !os.IsNotExist(err) {
+		t.Errorf("expected temporary file to be gone, got %v", err)
+	}
+}
@@ -0,0 +1,228 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"slices"
+
+	"github.com/lanrat/extsort"
+	"gitlab.com/tozd/go/mediawiki"
+	"golang.org/x/sync/errgroup"
+)
+
+// Item is the item-centric view of a name item, written as one line
+// of the NDJSON output. Unlike in the CSV output, labels and aliases
+// are grouped by language.
+type Item struct {
+	ID        string              `json:"id"`
+	Classes   []string            `json:"classes"`
+	Labels    map[string]string   `json:"labels"`
+	Aliases   map[string][]string `json:"aliases,omitempty"`
+	SiteLinks int                 `json:"sitelinks"`
+}
+
+// NewItem returns the Item for an entity, given the classes
+// that were matched by an output. Like in EntityNames, labels and
+// aliases that become empty when normalized are dropped, and so are
+// aliases that repeat an earlier alias in the same language.
+func NewItem(e *mediawiki.Entity, classes []string) *Item {
+	item := &Item{
+		ID:        e.ID,
+		Classes:   classes,
+		Labels:    make(map[string]string, len(e.Labels)),
+		SiteLinks: len(e.SiteLinks),
+	}
+	for lang, label := range e.Labels {
+		if name := NormalizeName(label.Value); name != "" {
+			item.Labels[lang] = name
+		}
+	}
+	for lang, aliases := range e.Aliases {
+		for _, alias := range aliases {
+			name := NormalizeName(alias.Value)
+			if name == "" || slices.Contains(item.Aliases[lang], name) {
+				continue
+			}
+			if item.Aliases == nil {
+				item.Aliases = make(map[string][]string, len(e.Aliases))
+			}
+			item.Aliases[lang] = append(item.Aliases[lang], name)
+		}
+	}
+	return item
+}
+
+// itemLine is a line of NDJSON, together with the numeric ID
+// of its item for sorting.
+type itemLine struct {
+	qid  int64
+	line []byte
+}
+
+func (item *Item) line() (itemLine, error) {
+	qid, err := parseQID(item.ID)
+	if err != nil {
+		return itemLine{}, err
+	}
+	line, err := json.Marshal(item)
+	if err != nil {
+		return itemLine{}, err
+	}
+	return itemLine{qid, line}, nil
+}
+
+// parseItemLine parses a line of NDJSON, such as from an earlier
+// extract, for passing it to ItemWriter.
+func parseItemLine(line []byte) (itemLine, error) {
+	var item struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(line, &item); err != nil {
+		return itemLine{}, err
+	}
+	qid, err := parseQID(item.ID)
+	if err != nil {
+		return itemLine{}, err
+	}
+	return itemLine{qid, line}, nil
+}
+
+func (l itemLine) ToBytes() []byte {
+	b := make([]byte, 8, 8+len(l.line))
+	binary.BigEndian.PutUint64(b, uint64(l.qid))
+	return append(b, l.line...)
+}
+
+func itemLineFromBytes(b []byte) extsort.SortType {
+	return itemLine{int64(binary.BigEndian.Uint64(b[:8])), b[8:]}
+}
+
+func itemLineIsLess(a, b extsort.SortType) bool {
+	return a.(itemLine).qid < b.(itemLine).qid
+}
+
+// ItemWriter sorts NDJSON lines by numeric item ID and writes them
+// into a gzipped file. The file gets written under a temporary name
+// and renamed into place by Close.
+type ItemWriter struct {
+	path       string
+	file       *os.File
+	compressor *gzip.Writer
+	writer     *bufio.Writer
+	sortChan   chan extsort.SortType
+	sortTask   *errgroup.Group
+	sortCtx    context.Context
+	closed     bool
+}
+
+func NewItemWriter(path string) (*ItemWriter, error) {
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	compressor, err := gzip.NewWriterLevel(file, 9)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	w := &ItemWriter{
+		path:       path,
+		file:       file,
+		compressor: compressor,
+		writer:     bufio.NewWriter(compressor),
+		sortChan:   make(chan extsort.SortType, 10000),
+	}
+
+	sorter, outChan, errChan := extsort.New(w.sortChan, itemLineFromBytes, itemLineIsLess, nil)
+	w.sortTask, w.sortCtx = errgroup.WithContext(context.Background())
+	w.sortTask.Go(func() error {
+		sorter.Sort(w.sortCtx)
+		return nil
+	})
+	w.sortTask.Go(func() error {
+		for l := range outChan {
+			if _, err := w.writer.Write(l.(itemLine).line); err != nil {
+				return err
+			}
+			if err := w.writer.WriteByte('\n'); err != nil {
+				return err
+			}
+		}
+		return <-errChan
+	})
+
+	return w, nil
+}
+
+func (w *ItemWriter) WriteItem(item *Item) error {
+	l, err := item.line()
+	if err != nil {
+		return err
+	}
+	return w.write(l)
+}
+
+func (w *ItemWriter) write(l itemLine) error {
+	select {
+	case w.sortChan <- l:
+		return nil
+	case <-w.sortCtx.Done():
+		return context.Cause(w.sortCtx)
+	}
+}
+
+func (w *ItemWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	close(w.sortChan)
+
+	if err := w.sortTask.Wait(); err != nil {
+		w.abort()
+		return err
+	}
+
+	if err := w.writer.Flush(); err != nil {
+		w.abort()
+		return err
+	}
+
+	if err := w.compressor.Close(); err != nil {
+		w.abort()
+		return err
+	}
+
+	if err := w.file.Close(); err != nil {
+		w.abort()
+		return err
+	}
+
+	return os.Rename(w.file.Name(), w.path)
+}
+
+// Abort closes the ItemWriter after an error, and removes
+// its temporary file.
+func (w *ItemWriter) Abort() {
+	if !w.closed {
+		w.closed = true
+		close(w.sortChan)
+		w.sortTask.Wait()
+	}
+	w.abort()
+}
+
+func (w *ItemWriter) abort() {
+	w.file.Close()
+	os.Remove(w.file.Name())
+}
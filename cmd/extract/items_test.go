@@ -0,0 +1,114 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+func TestNewItem(t *testing.T) {
+	e := &mediawiki.Entity{
+		ID: "Q167755",
+		Labels: map[string]mediawiki.LanguageValue{
+			"de": {Language: "de", Value: "Astrid"},
+			"sv": {Language: "sv", Value: "Astrid"},
+		},
+		Aliases: map[string][]mediawiki.LanguageValue{
+			"de": {{Language: "de", Value: "Astrida"}, {Language: "de", Value: "Asta"}},
+		},
+		SiteLinks: map[string]mediawiki.SiteLink{
+			"dewiki": {Site: "dewiki", Title: "Astrid"},
+		},
+	}
+	got := NewItem(e, []string{"Q11879590"})
+	want := &Item{
+		ID:        "Q167755",
+		Classes:   []string{"Q11879590"},
+		Labels:    map[string]string{"de": "Astrid", "sv": "Astrid"},
+		Aliases:   map[string][]string{"de": {"Astrida", "Asta"}},
+		SiteLinks: 1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestNewItemSkipsEmptyAndDuplicateNames(t *testing.T) {
+	e := &mediawiki.Entity{
+		ID: "Q167755",
+		Labels: map[string]mediawiki.LanguageValue{
+			"de": {Language: "de", Value: "Astrid"},
+			"sv": {Language: "sv", Value: " \u00A0"},
+		},
+		Aliases: map[string][]mediawiki.LanguageValue{
+			"de": {
+				{Language: "de", Value: "Asta"},
+				{Language: "de", Value: " Asta "},
+				{Language: "de", Value: ""},
+				{Language: "de", Value: "Astrida"},
+			},
+			"sv": {{Language: "sv", Value: " "}},
+		},
+	}
+	got := NewItem(e, []string{"Q11879590"})
+	want := &Item{
+		ID:      "Q167755",
+		Classes: []string{"Q11879590"},
+		Labels:  map[string]string{"de": "Astrid"},
+		Aliases: map[string][]string{"de": {"Asta", "Astrida"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestItemWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "names.ndjson.gz")
+	w, err := NewItemWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Q21050435 sorts before Q4878552 as a string,
+	// but not as a number.
+	for _, item := range []*Item{
+		{ID: "Q21050435", Classes: []string{"Q101352"}, Labels: map[string]string{"en": "Wilde"}},
+		{ID: "Q4878552", Classes: []string{"Q101352"}, Labels: map[string]string{}},
+	} {
+		if err := w.WriteItem(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l, err := parseItemLine([]byte(`{"id":"Q167755","classes":["Q11879590"],"labels":{"de":"Astrid"},"sitelinks":3}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.write(l); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readExtract(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"id":"Q167755","classes":["Q11879590"],"labels":{"de":"Astrid"},"sitelinks":3}
+{"id":"Q4878552","classes":["Q101352"],"labels":{},"sitelinks":0}
+{"id":"Q21050435","classes":["Q101352"],"labels":{"en":"Wilde"},"sitelinks":0}
+`
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("expected temporary file to be gone, got %v", err)
+	}
+}
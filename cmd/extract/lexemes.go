@@ -0,0 +1,283 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/tozd/go/errors"
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+// The lexical category of lexemes for names, "proper noun" (Q147276).
+const properNoun = "Q147276"
+
+// Form is an inflected form of a name, such as the Polish genitive
+// "Kowalskiego" of "Kowalski", taken from a Wikidata lexeme whose
+// sense is linked to a name item by "item for this sense" (P5137).
+type Form struct {
+	Form                string
+	ID                  string   // Wikidata ID of the name item, such as "Q145210"
+	Language            string   // language code of the representation
+	Lexeme              string   // such as "L1234"
+	FormID              string   // such as "L1234-F2"
+	GrammaticalFeatures []string // Wikidata IDs, such as "Q146233" (genitive)
+}
+
+// FormHeader is the CSV header for the columns returned by Form.Record().
+var FormHeader = []string{"Form", "WikidataID", "Language", "Lexeme", "FormID", "GrammaticalFeatures"}
+
+// Record returns the CSV columns for a Form.
+func (f *Form) Record() []string {
+	return []string{
+		f.Form,
+		f.ID,
+		f.Language,
+		f.Lexeme,
+		f.FormID,
+		strings.Join(f.GrammaticalFeatures, ";"),
+	}
+}
+
+// compareForms orders forms like the names in the extracts, by
+// spelling and numeric Wikidata ID, then by form and language.
+func compareForms(a, b *Form) int {
+	an, bn := Name{Name: a.Form, ID: a.ID}, Name{Name: b.Form, ID: b.ID}
+	return cmp.Or(
+		compareNameKeys(&an, &bn),
+		strings.Compare(a.FormID, b.FormID),
+		strings.Compare(a.Language, b.Language))
+}
+
+// findLexemesDump returns the date and path of the lexemes dump to
+// use with an entities dump. Wikimedia publishes both in dated
+// directories, but not necessarily on the same day, so we take the
+// newest lexemes dump that is not newer than the entities dump.
+// Picking "latest-lexemes.json.bz2" instead could mix in lexemes
+// from a later week when re-running an older dump. If there is no
+// such lexemes dump, the returned path is empty.
+func findLexemesDump(dumpsPath string, entitiesDate time.Time) (time.Time, string, error) {
+	dir := filepath.Join(dumpsPath, "wikidatawiki", "entities")
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return time.Time{}, "", nil
+	} else if err != nil {
+		return time.Time{}, "", err
+	}
+
+	// ReadDir returns the entries sorted by name, which for dated
+	// directories means from oldest to newest.
+	for i := len(entries) - 1; i >= 0; i-- {
+		day := entries[i].Name()
+		date, err := time.Parse("20060102", day)
+		if err != nil || date.After(entitiesDate) {
+			continue
+		}
+		path := filepath.Join(dir, day, fmt.Sprintf("wikidata-%s-lexemes.json.bz2", day))
+		if _, err := os.Stat(path); err == nil {
+			return date, path, nil
+		}
+	}
+	return time.Time{}, "", nil
+}
+
+// formsPath returns the path of the inflected forms of an extract,
+// such as "givennames-forms-20230418.csv.gz" in the working directory.
+func formsPath(workdir string, name string, date time.Time) string {
+	return changesPath(workdir, name, "forms", date)
+}
+
+// lexeme is the part of a lexeme that is needed for finding forms.
+type lexeme struct {
+	ID              string `json:"id"`
+	LexicalCategory string `json:"lexicalCategory"`
+	Forms           []struct {
+		ID                  string                             `json:"id"`
+		Representations     map[string]mediawiki.LanguageValue `json:"representations"`
+		GrammaticalFeatures []string                           `json:"grammaticalFeatures"`
+	} `json:"forms"`
+	Senses []struct {
+		Claims map[string][]mediawiki.Statement `json:"claims"`
+	} `json:"senses"`
+}
+
+// decodeLexeme decodes the JSON of a lexeme. Like the XML dumps of
+// items, the lexemes dump writes empty maps as [], which we drop.
+func decodeLexeme(raw []byte) (*lexeme, error) {
+	var v interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(dropEmptyLists(v))
+	if err != nil {
+		return nil, err
+	}
+	var l lexeme
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// LexemeForms returns the forms of a lexeme for the name items in
+// ids, which are keyed by numeric Wikidata ID. Only proper nouns
+// are considered.
+func LexemeForms(l *lexeme, ids map[int64]struct{}) []Form {
+	if l.LexicalCategory != properNoun {
+		return nil
+	}
+
+	var items []int64
+	for _, sense := range l.Senses {
+		e := mediawiki.Entity{Claims: sense.Claims}
+		for _, qid := range ItemClaims(&e, "P5137") {
+			if _, ok := ids[qid]; ok {
+				items = append(items, qid)
+			}
+		}
+	}
+	slices.Sort(items)
+	items = slices.Compact(items)
+
+	var forms []Form
+	for _, qid := range items {
+		for _, f := range l.Forms {
+			features := slices.Clone(f.GrammaticalFeatures)
+			slices.SortFunc(features, func(a, b string) int {
+				an, bn := Name{ID: a}, Name{ID: b}
+				return compareNameKeys(&an, &bn)
+			})
+			for lang, rep := range f.Representations {
+				form := NormalizeName(rep.Value)
+				if form == "" {
+					continue
+				}
+				forms = append(forms, Form{
+					Form:                form,
+					ID:                  fmt.Sprintf("Q%d", qid),
+					Language:            lang,
+					Lexeme:              l.ID,
+					FormID:              f.ID,
+					GrammaticalFeatures: features,
+				})
+			}
+		}
+	}
+	return forms
+}
+
+// ExtractForms reads a lexemes dump and writes the forms of the names
+// in each output to a gzipped CSV file next to its extract. The names
+// are taken from the extracts that Close has written.
+func ExtractForms(ctx context.Context, dumpPath string, outputs []*Output, workers int) error {
+	ids := make([]map[int64]struct{}, len(outputs))
+	for i, o := range outputs {
+		var err error
+		if ids[i], err = extractIDs(extractPath(o.workdir, o.name, o.date)); err != nil {
+			return err
+		}
+	}
+
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	var mutex sync.Mutex
+	forms := make([][]Form, len(outputs))
+	err := mediawiki.Process(
+		ctx,
+		&mediawiki.ProcessConfig[rawEntity]{
+			Path:                   dumpPath,
+			FileType:               mediawiki.JSONArray,
+			Compression:            mediawiki.BZIP2,
+			DecompressionThreads:   workers,
+			DecodingThreads:        1,
+			ItemsProcessingThreads: workers,
+			Process: func(_ context.Context, raw rawEntity) errors.E {
+				// Most lexemes are not proper nouns, and can be
+				// skipped without decoding them.
+				if !bytes.Contains(raw, []byte(properNoun)) || !bytes.Contains(raw, []byte("P5137")) {
+					return nil
+				}
+				l, err := decodeLexeme(raw)
+				if err != nil {
+					return errors.WithStack(err)
+				}
+				for i := range outputs {
+					f := LexemeForms(l, ids[i])
+					if len(f) == 0 {
+						continue
+					}
+					mutex.Lock()
+					forms[i] = append(forms[i], f...)
+					mutex.Unlock()
+				}
+				return nil
+			},
+		})
+	if err != nil {
+		return err
+	}
+
+	for i, o := range outputs {
+		slices.SortFunc(forms[i], func(a, b Form) int { return compareForms(&a, &b) })
+		path := formsPath(o.workdir, o.name, o.date)
+		if err := writeForms(path, forms[i]); err != nil {
+			return err
+		}
+		o.files = append(o.files, path)
+		o.forms = int64(len(forms[i]))
+	}
+	return nil
+}
+
+// extractIDs returns the numeric IDs of the items in an extract.
+func extractIDs(path string) (map[int64]struct{}, error) {
+	r, err := openExtractReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	ids := make(map[int64]struct{}, 100000)
+	for {
+		group, err := r.ReadGroup()
+		if err != nil {
+			return nil, err
+		}
+		if len(group) == 0 {
+			return ids, nil
+		}
+		if qid, err := parseQID(group[0].ID); err == nil {
+			ids[qid] = struct{}{}
+		}
+	}
+}
+
+// writeForms writes sorted forms into a gzipped CSV file.
+func writeForms(path string, forms []Form) error {
+	w, err := newCSVFileWriter(path, FormHeader)
+	if err != nil {
+		return err
+	}
+	defer w.Abort()
+	for i := range forms {
+		if err := w.Write(forms[i].Record()); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
@@ -0,0 +1,143 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFindLexemesDump(t *testing.T) {
+	dumpsDir := t.TempDir()
+	dir := filepath.Join(dumpsDir, "wikidatawiki", "entities")
+	entitiesDate := time.Date(2025, 2, 12, 0, 0, 0, 0, time.UTC)
+
+	date, path, err := findLexemesDump(dumpsDir, entitiesDate)
+	if path != "" || err != nil {
+		t.Errorf("got %q %v without dumps directory, want empty path", path, err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, "20250212"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	date, path, err = findLexemesDump(dumpsDir, entitiesDate)
+	if path != "" || err != nil {
+		t.Errorf("got %q %v without lexemes dump, want empty path", path, err)
+	}
+
+	// A lexemes dump that is newer than the entities dump, such as
+	// when re-running an older week, should not get picked even if
+	// "latest-lexemes.json.bz2" points to it.
+	for _, day := range []string{"20250205", "20250207", "20250214"} {
+		if err := os.MkdirAll(filepath.Join(dir, day), 0755); err != nil {
+			t.Fatal(err)
+		}
+		name := filepath.Join(dir, day, "wikidata-"+day+"-lexemes.json.bz2")
+		if err := os.WriteFile(name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = os.Symlink(filepath.Join("20250214", "wikidata-20250214-lexemes.json.bz2"),
+		filepath.Join(dir, "latest-lexemes.json.bz2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	date, path, err = findLexemesDump(dumpsDir, entitiesDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPath := filepath.Join(dir, "20250207", "wikidata-20250207-lexemes.json.bz2")
+	if path != wantPath {
+		t.Errorf("got %q, want %q", path, wantPath)
+	}
+	if want := time.Date(2025, 2, 7, 0, 0, 0, 0, time.UTC); !date.Equal(want) {
+		t.Errorf("got date %s, want %s", date, want)
+	}
+
+	// The lexemes dump of the very same day qualifies.
+	entitiesDate = time.Date(2025, 2, 14, 0, 0, 0, 0, time.UTC)
+	if _, path, _ = findLexemesDump(dumpsDir, entitiesDate); !strings.HasSuffix(path, "wikidata-20250214-lexemes.json.bz2") {
+		t.Errorf("got %q, want lexemes dump of 2025-02-14", path)
+	}
+}
+
+func TestLexemeForms(t *testing.T) {
+	l, err := decodeLexeme([]byte(`{
+		"id": "L100",
+		"lexicalCategory": "Q147276",
+		"claims": [],
+		"forms": [
+			{"id": "L100-F1", "representations": {"pl": {"language": "pl", "value": "Weiss"}},
+			 "grammaticalFeatures": ["Q131105", "Q110786"], "claims": []},
+			{"id": "L100-F2", "representations": {"pl": {"language": "pl", "value": " Weissa "}},
+			 "grammaticalFeatures": [], "claims": []}
+		],
+		"senses": [
+			{"id": "L100-S1", "glosses": {}, "claims": []},
+			{"id": "L100-S2", "glosses": {}, "claims": {"P5137": [{"mainsnak": {"snaktype": "value",
+			 "property": "P5137", "datavalue": {"value": {"entity-type": "item", "numeric-id": 145210,
+			 "id": "Q145210"}, "type": "wikibase-entityid"}}, "type": "statement", "rank": "normal"}]}}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := map[int64]struct{}{145210: {}}
+	var got []string
+	for _, f := range LexemeForms(l, ids) {
+		got = append(got, strings.Join(f.Record(), ","))
+	}
+	want := "Weiss,Q145210,pl,L100,L100-F1,Q110786;Q131105 Weissa,Q145210,pl,L100,L100-F2,"
+	if s := strings.Join(got, " "); s != want {
+		t.Errorf("got %q, want %q", s, want)
+	}
+
+	if forms := LexemeForms(l, map[int64]struct{}{7: {}}); len(forms) != 0 {
+		t.Errorf("got %v for unrelated name items, want none", forms)
+	}
+
+	l.LexicalCategory = "Q1084" // noun
+	if forms := LexemeForms(l, ids); len(forms) != 0 {
+		t.Errorf("got %v for common noun, want none", forms)
+	}
+}
+
+func TestExtractorForms(t *testing.T) {
+	workdir := t.TempDir()
+	lexemesPath := filepath.Join("testdata", "full", "lexemes.json.bz2")
+	m := runFullExtract(t, workdir, func(ex *Extractor) {
+		ex.LexemesPath = lexemesPath
+		ex.LexemesDate = time.Date(2023, 4, 14, 0, 0, 0, 0, time.UTC)
+	})
+	checkExtracts(t, workdir)
+
+	for _, tc := range []struct {
+		output string
+		want   string
+	}{
+		{"familynames", "Weiss,Q145210,pl,L100,L100-F1,Q110786;Q131105\n" +
+			"Weissa,Q145210,pl,L100,L100-F2,Q110786;Q146233\n" +
+			"Weissowie,Q145210,pl,L100,L100-F3,Q131105;Q146786\n"},
+		{"givennames", "Ivar,Q127069,sv,L101,L101-F1,Q131105\n" +
+			"Ivars,Q127069,sv,L101,L101-F2,Q146233\n"},
+	} {
+		got, err := readExtract(filepath.Join(workdir, tc.output+"-forms-20230418.csv.gz"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := strings.Join(FormHeader, ",") + "\n" + tc.want
+		if got != want {
+			t.Errorf("%s: got %q, want %q", tc.output, got, want)
+		}
+	}
+
+	if m.LexemesPath != lexemesPath || m.LexemesDate != "2023-04-14" || m.Outputs[0].Forms != 3 || m.Outputs[1].Forms != 2 {
+		t.Errorf("got lexemes %q of %q, forms %d %d", m.LexemesPath, m.LexemesDate, m.Outputs[0].Forms, m.Outputs[1].Forms)
+	}
+}
@@ -4,24 +4,77 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
 	var dumps = flag.String("dumps", "/public/dumps/public", "path to Wikimedia dumps")
 	var workdir = flag.String("workdir", ".", "path to working directory")
+	var configPath = flag.String("config", "", "path to configuration file, or empty for the built-in default")
+	var localSubclasses = flag.Bool("local-subclasses", false, "compute subclasses from the dump instead of querying Wikidata")
+	var sparqlEndpoint = flag.String("sparql-endpoint", "https://query.wikidata.org/sparql", "URL of the Wikidata Query Service")
+	var userAgent = flag.String("user-agent", "WikidataNamesBot/1.0", "User-Agent header for querying Wikidata")
+	var workers = flag.Int("workers", 0, "number of worker goroutines, or 0 for one per CPU")
+	var incremental = flag.Bool("incremental", false, "update the latest extract from daily incremental dumps")
 	flag.Parse()
 
+	config, err := ReadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(1)
+	}
+
 	edate, epath, err := findEntitiesDump(*dumps)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
 		os.Exit(1)
 	}
 
-	shouldRun, err := ShouldRun(edate, *workdir)
+	if *incremental {
+		baseDate, err := FindLatestExtract(config, *workdir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			os.Exit(1)
+		}
+
+		incrDumps, err := FindIncrementalDumps(*dumps, baseDate)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			os.Exit(1)
+		}
+
+		if len(incrDumps) == 0 {
+			day := baseDate.Format("2006-01-02")
+			fmt.Fprintf(os.Stderr, "no incremental dumps newer than %s\n", day)
+			os.Exit(0)
+		}
+
+		subclasser, err := newSubclasser(*localSubclasses, epath, *sparqlEndpoint, *userAgent, *workdir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			os.Exit(1)
+		}
+
+		updater, err := NewUpdater(config, baseDate, incrDumps, *workdir, subclasser)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			os.Exit(1)
+		}
+
+		if err := updater.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	shouldRun, err := ShouldRun(config, edate, *workdir)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
 		os.Exit(1)
@@ -33,15 +86,47 @@ func main() {
 		os.Exit(0)
 	}
 
-	client := &http.Client{}
-	extractor, err := NewExtractor(epath, edate, *workdir, client)
+	subclasser, err := newSubclasser(*localSubclasses, epath, *sparqlEndpoint, *userAgent, *workdir)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
 		os.Exit(1)
 	}
 
-	if err := extractor.Run(); err != nil {
+	extractor, err := NewExtractor(config, epath, edate, *workdir, subclasser)
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
 		os.Exit(1)
 	}
+	extractor.Workers = *workers
+	extractor.LexemesDate, extractor.LexemesPath, err = findLexemesDump(*dumps, edate)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(1)
+	}
+
+	// On SIGTERM or Ctrl-C, stop processing; the next invocation
+	// will resume from the last checkpoint.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := extractor.Run(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+// newSubclasser returns a Subclasser that either computes subclasses
+// from the entities dump, or that queries the Wikidata Query Service.
+func newSubclasser(local bool, dumpPath, endpoint, userAgent, workdir string) (Subclasser, error) {
+	if local {
+		tree, err := ReadClassTree(dumpPath)
+		if err != nil {
+			return nil, err
+		}
+		return tree, nil
+	}
+	q := NewQueryService(&http.Client{}, workdir)
+	q.Endpoint = endpoint
+	q.UserAgent = userAgent
+	return q, nil
 }
@@ -0,0 +1,212 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"time"
+)
+
+// Manifest is a machine-readable record of an extraction run, written
+// to "manifest-YYYYMMDD.json" next to the extracts. The webserver reads
+// it to serve the extracts without having to hash them again.
+//
+// If names got filtered, Rejected counts the rejected names, and
+// RejectedFile describes the file that lists them. Likewise, Countries
+// counts the rows of the file on the countries of name bearers,
+// Genders those of the file on the sex or gender of given name bearers,
+// Decades those of the file on their decades of birth, and Persons
+// those of the file on how humans combine names. LexemesPath is the
+// lexemes dump that the inflected forms were taken from, and
+// LexemesDate the date of that dump.
+//
+// For extracts that Updater has brought up to date, IncrementalPaths
+// lists the incremental dumps that got applied, and BaseDate is the
+// date of the full run that the update, or a chain of updates, started
+// from. The files that only full runs write, such as the persons file
+// or the names added since the previous extract, are those of BaseDate.
+type Manifest struct {
+	DumpPath         string           `json:"dump_path"`
+	IncrementalPaths []string         `json:"incremental_paths,omitempty"`
+	BaseDate         string           `json:"base_date,omitempty"`
+	LexemesPath      string           `json:"lexemes_path,omitempty"`
+	LexemesDate      string           `json:"lexemes_date,omitempty"`
+	DumpDate         string           `json:"dump_date"`
+	ToolVersion      string           `json:"tool_version"`
+	Started          time.Time        `json:"started"`
+	Duration         float64          `json:"duration_seconds"`
+	Outputs          []ManifestOutput `json:"outputs"`
+	Rejected         int64            `json:"rejected"`
+	RejectedFile     *ManifestFile    `json:"rejected_file,omitempty"`
+	Countries        int64            `json:"countries"`
+	CountriesFile    *ManifestFile    `json:"countries_file,omitempty"`
+	Genders          int64            `json:"genders"`
+	GendersFile      *ManifestFile    `json:"genders_file,omitempty"`
+	Decades          int64            `json:"decades"`
+	DecadesFile      *ManifestFile    `json:"decades_file,omitempty"`
+	Persons          int64            `json:"persons"`
+	PersonsFile      *ManifestFile    `json:"persons_file,omitempty"`
+}
+
+// ManifestOutput describes what a run has produced for one output.
+// Rows is the number of names, Items the number of distinct Wikidata
+// items they come from. Classes is the resolved set of all classes
+// whose instances went into the output, including subclasses of the
+// configured RootClasses. If there was an earlier extract, Added and
+// Removed count the names that changed since PreviousDate. Forms
+// is the number of inflected forms found in the lexemes dump.
+type ManifestOutput struct {
+	Name         string         `json:"name"`
+	Rows         int64          `json:"rows"`
+	Items        int64          `json:"items"`
+	PreviousDate string         `json:"previous_date,omitempty"`
+	Added        int64          `json:"added"`
+	Removed      int64          `json:"removed"`
+	Forms        int64          `json:"forms,omitempty"`
+	RootClasses  []string       `json:"root_classes"`
+	Classes      []string       `json:"classes"`
+	Files        []ManifestFile `json:"files"`
+}
+
+type ManifestFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+func manifestPath(workdir string, dumpDate time.Time) string {
+	day := dumpDate.Format("20060102")
+	return filepath.Join(workdir, fmt.Sprintf("manifest-%s.json", day))
+}
+
+// ReadManifest reads the manifest of the run for a dump.
+func ReadManifest(workdir string, dumpDate time.Time) (*Manifest, error) {
+	data, err := os.ReadFile(manifestPath(workdir, dumpDate))
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %v", manifestPath(workdir, dumpDate), err)
+	}
+	return &m, nil
+}
+
+func (m *Manifest) Write(workdir string, dumpDate time.Time) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(manifestPath(workdir, dumpDate), data)
+}
+
+// manifest describes the extract files that Close has written.
+func (o *Output) manifest() (ManifestOutput, error) {
+	m := ManifestOutput{
+		Name:        o.name,
+		Rows:        o.rows,
+		Items:       o.itemCount,
+		Added:       o.added,
+		Removed:     o.removed,
+		Forms:       o.forms,
+		RootClasses: formatClasses(o.rootClasses),
+		Classes:     formatClasses(o.wikidataClasses),
+		Files:       make([]ManifestFile, 0, len(o.files)),
+	}
+	if !o.previous.IsZero() {
+		m.PreviousDate = o.previous.Format("2006-01-02")
+	}
+	for _, path := range o.files {
+		f, err := manifestFile(path)
+		if err != nil {
+			return ManifestOutput{}, err
+		}
+		m.Files = append(m.Files, f)
+	}
+	return m, nil
+}
+
+// manifestFile describes a file that has been written by a run.
+func manifestFile(path string) (ManifestFile, error) {
+	size, hash, err := hashFile(path)
+	if err != nil {
+		return ManifestFile{}, err
+	}
+	return ManifestFile{Name: filepath.Base(path), Size: size, SHA256: hash}, nil
+}
+
+// formatClasses returns the IDs in a ClassSet, such as "Q202444",
+// in numeric order.
+func formatClasses(set ClassSet) []string {
+	ids := make([]int64, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	result := make([]string, 0, len(ids))
+	for _, id := range ids {
+		result = append(result, fmt.Sprintf("Q%d", id))
+	}
+	return result
+}
+
+// hashFile returns the size and the hex-encoded SHA-256 of a file.
+func hashFile(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// toolVersion returns the version of this program, as recorded
+// by the Go toolchain at build time.
+func toolVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	version := info.Main.Version
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			version += " " + s.Value
+		}
+	}
+	return version
+}
+
+// nameCounter is a NameSink that counts names and distinct items.
+type nameCounter struct {
+	rows  int64
+	items map[string]struct{}
+}
+
+func newNameCounter() *nameCounter {
+	return &nameCounter{items: make(map[string]struct{}, 100000)}
+}
+
+func (c *nameCounter) WriteName(n *Name) error {
+	c.rows += 1
+	c.items[n.ID] = struct{}{}
+	return nil
+}
+
+func (c *nameCounter) Close() error {
+	return nil
+}
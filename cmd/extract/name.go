@@ -5,84 +5,250 @@ package main
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/csv"
+	"fmt"
 	"golang.org/x/sync/errgroup"
 	"io"
+	"runtime"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/lanrat/extsort"
 )
 
 type Name struct {
-	Name string
-	ID   string
+	Name           string
+	ID             string
+	Languages      []string
+	Source         string   // "label", "alias", or a property such as "P1705"
+	Scripts        []string // ISO 15924 codes, see Scripts()
+	WritingSystems []string // Wikidata IDs from P282 statements
+	Classes        []string // most specific matched classes, see MostSpecificClasses()
+	Folded         string   // key for matching, see FoldName()
+	Bearers        int64    // number of humans with this name, see Output.WriteBearers()
+}
+
+// NameHeader is the CSV header for the columns returned by Name.Record().
+var NameHeader = []string{
+	"Name", "WikidataID", "Languages", "Source", "Scripts", "WritingSystems",
+	"Classes", "FoldedName", "Bearers",
+}
+
+// Record returns the CSV columns for a Name. Multi-valued columns
+// are separated by semicolons.
+func (n Name) Record() []string {
+	return []string{
+		n.Name,
+		n.ID,
+		strings.Join(n.Languages, ";"),
+		n.Source,
+		strings.Join(n.Scripts, ";"),
+		strings.Join(n.WritingSystems, ";"),
+		strings.Join(n.Classes, ";"),
+		n.Folded,
+		strconv.FormatInt(n.Bearers, 10),
+	}
+}
+
+// NameFromRecord is the inverse of Name.Record().
+func NameFromRecord(r []string) (Name, error) {
+	if len(r) != len(NameHeader) {
+		return Name{}, fmt.Errorf("expected %d columns, got %d", len(NameHeader), len(r))
+	}
+	bearers, err := strconv.ParseInt(r[8], 10, 64)
+	if err != nil {
+		return Name{}, fmt.Errorf("bad bearers count %q", r[8])
+	}
+	return Name{
+		Name:           r[0],
+		ID:             r[1],
+		Languages:      splitList(r[2]),
+		Source:         r[3],
+		Scripts:        splitList(r[4]),
+		WritingSystems: splitList(r[5]),
+		Classes:        splitList(r[6]),
+		Folded:         r[7],
+		Bearers:        bearers,
+	}, nil
+}
+
+func splitList(s string) []string {
+	if len(s) == 0 {
+		return nil
+	}
+	return strings.Split(s, ";")
 }
 
 func (n Name) ToBytes() []byte {
-	var buf bytes.Buffer
-	buf.WriteString(n.Name)
-	buf.WriteRune(0)
-	buf.WriteString(n.ID)
-	return buf.Bytes()
+	return []byte(strings.Join(n.Record(), "\x00"))
 }
 
-func NameFromBytes(b []byte) extsort.SortType {
-	for i, ch := range b {
-		if ch == 0 {
-			return Name{Name: string(b[0:i]), ID: string(b[i+1 : len(b)])}
-		}
+// NameFromBytes is the inverse of Name.ToBytes().
+func NameFromBytes(b []byte) (Name, error) {
+	n, err := NameFromRecord(strings.Split(string(b), "\x00"))
+	if err != nil {
+		return Name{}, fmt.Errorf("bad name record %q: %w", b, err)
 	}
-	return Name{}
+	return n, nil
 }
 
+// NameIsLess defines the order of names in the extracts. It is total,
+// so that the output does not depend on how names get chunked
+// while sorting: names are ordered by spelling, then by numeric
+// Wikidata ID, then by source, and finally by all other columns.
 func NameIsLess(a, b extsort.SortType) bool {
-	return a.(Name).Name < b.(Name).Name
+	an, bn := a.(Name), b.(Name)
+	return compareNames(&an, &bn) < 0
 }
 
+// compareNames returns 0 if two names are exactly the same, otherwise
+// a negative or positive number according to the order of NameIsLess.
+func compareNames(a, b *Name) int {
+	if c := compareNameKeys(a, b); c != 0 {
+		return c
+	}
+	if c := strings.Compare(a.Source, b.Source); c != 0 {
+		return c
+	}
+	return bytes.Compare(a.ToBytes(), b.ToBytes())
+}
+
+// compareNameKeys compares two names by spelling and numeric
+// Wikidata ID only, ignoring all other columns.
+func compareNameKeys(a, b *Name) int {
+	if c := strings.Compare(a.Name, b.Name); c != 0 {
+		return c
+	}
+	if a.ID != b.ID {
+		aq, aErr := parseQID(a.ID)
+		bq, bErr := parseQID(b.ID)
+		if aErr == nil && bErr == nil {
+			return cmp.Compare(aq, bq)
+		}
+		return strings.Compare(a.ID, b.ID)
+	}
+	return 0
+}
+
+// NameSink receives the names of an output in sorted order,
+// such as for writing them into a database.
+type NameSink interface {
+	WriteName(n *Name) error
+	Close() error
+}
+
+// NameWriter sorts names and writes them as CSV, and optionally
+// also to additional sinks. Names that occur more than once get
+// written only once. It is safe to call WriteName from
+// multiple goroutines concurrently.
 type NameWriter struct {
-	mutex    sync.Mutex
+	mutex    sync.RWMutex
 	closed   bool
 	writer   *csv.Writer
+	sinks    []NameSink
 	sortChan chan extsort.SortType
 	sortTask *errgroup.Group
+	sortCtx  context.Context
 }
 
-func NewNameWriter(w io.Writer) (*NameWriter, error) {
+func NewNameWriter(w io.Writer, sinks ...NameSink) (*NameWriter, error) {
+	return newNameWriter(w, nil, sinks...)
+}
+
+// sortConfig returns the configuration for sorting with a number of
+// workers. The input gets sharded into chunks, which the workers sort
+// in parallel before extsort merges them. Zero means one per CPU.
+func sortConfig(workers int) *extsort.Config {
+	config := extsort.DefaultConfig()
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	config.NumWorkers = workers
+	return config
+}
+
+// newNameWriter returns a NameWriter that sorts with a custom
+// configuration, such as a small chunk size for testing.
+func newNameWriter(w io.Writer, config *extsort.Config, sinks ...NameSink) (*NameWriter, error) {
 	writer := csv.NewWriter(w)
-	if err := writer.Write([]string{"Name", "WikidataID"}); err != nil {
+	if err := writer.Write(NameHeader); err != nil {
 		return nil, err
 	}
 
+	// The sorter cannot pass on errors from decoding the names that
+	// it has stored in temporary files, so we remember the first one.
+	var decodeMutex sync.Mutex
+	var decodeErr error
+	fromBytes := func(b []byte) extsort.SortType {
+		n, err := NameFromBytes(b)
+		if err != nil {
+			decodeMutex.Lock()
+			if decodeErr == nil {
+				decodeErr = err
+			}
+			decodeMutex.Unlock()
+		}
+		return n
+	}
+
 	inChan := make(chan extsort.SortType, 50000)
-	sorter, outChan, errChan := extsort.New(inChan, NameFromBytes, NameIsLess, nil)
+	sorter, outChan, errChan := extsort.New(inChan, fromBytes, NameIsLess, config)
 	task, ctx := errgroup.WithContext(context.Background())
 	task.Go(func() error {
 		sorter.Sort(ctx)
 		return nil
 	})
 	task.Go(func() error {
+		var last *Name
 		for n := range outChan {
 			name := n.(Name)
-			if err := writer.Write([]string{name.Name, name.ID}); err != nil {
+			if last != nil && compareNames(last, &name) == 0 {
+				continue
+			}
+			last = &name
+			if err := writer.Write(name.Record()); err != nil {
 				return err
 			}
+			for _, sink := range sinks {
+				if err := sink.WriteName(&name); err != nil {
+					return err
+				}
+			}
 		}
 		if err := <-errChan; err != nil {
 			return err
 		}
-		return nil
+		decodeMutex.Lock()
+		defer decodeMutex.Unlock()
+		return decodeErr
 	})
 	return &NameWriter{
 		writer:   writer,
+		sinks:    sinks,
 		sortChan: inChan,
 		sortTask: task,
+		sortCtx:  ctx,
 	}, nil
 }
 
 func (w *NameWriter) WriteName(n *Name) error {
-	w.sortChan <- *n
-	return nil
+	// Holding a read lock while sending keeps Close() from closing
+	// the channel under our feet, but lets other producers proceed.
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	if w.closed {
+		return fmt.Errorf("NameWriter already closed")
+	}
+
+	select {
+	case w.sortChan <- *n:
+		return nil
+	case <-w.sortCtx.Done():
+		return fmt.Errorf("NameWriter failed: %w", context.Cause(w.sortCtx))
+	}
 }
 
 func (w *NameWriter) Close() error {
@@ -94,11 +260,19 @@ func (w *NameWriter) Close() error {
 	w.closed = true
 	close(w.sortChan)
 
-	if err := w.sortTask.Wait(); err != nil {
+	// Sinks get closed even if sorting failed, so they can
+	// release their resources.
+	err := w.sortTask.Wait()
+	for _, sink := range w.sinks {
+		if sinkErr := sink.Close(); err == nil {
+			err = sinkErr
+		}
+	}
+	if err != nil {
 		return err
 	}
 
 	w.writer.Flush()
 
-	return nil
+	return w.writer.Error()
 }
@@ -5,26 +5,69 @@ package main
 
 import (
 	"bytes"
+	"compress/bzip2"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/lanrat/extsort"
+	"gitlab.com/tozd/go/mediawiki"
 )
 
 func TestNameToBytes(t *testing.T) {
-	want := Name{"Foo", "Q123"}
-	got := NameFromBytes(want.ToBytes()).(Name)
-	if got.Name != want.Name || got.ID != want.ID {
+	want := Name{
+		Name:           "Foo",
+		ID:             "Q123",
+		Languages:      []string{"de", "en"},
+		Source:         "alias",
+		Scripts:        []string{"Latn"},
+		WritingSystems: []string{"Q8229", "Q8209"},
+		Classes:        []string{"Q12308941"},
+		Folded:         "foo",
+	}
+	got, err := NameFromBytes(want.ToBytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
 		t.Errorf("got %v, want %v", got, want)
 	}
+
+	// A truncated record must not turn into an empty name.
+	b := want.ToBytes()
+	if _, err := NameFromBytes(b[:len(b)-4]); err == nil {
+		t.Error("expected error for truncated record")
+	}
 }
 
 func TestNameIsLess(t *testing.T) {
-	anna := Name{"Anna", "Q123"}
-	bob := Name{"Bob", "Q124"}
+	anna := Name{Name: "Anna", ID: "Q123"}
+	bob := Name{Name: "Bob", ID: "Q124"}
 	if got := NameIsLess(anna, bob); got != true {
 		t.Errorf("got NameIsLess(anna, bob) == %v", got)
 	}
 	if got := NameIsLess(anna, anna); got != false {
 		t.Errorf("got NameIsLess(anna, anna) == %v", got)
 	}
+	// Q99 sorts before Q123 as a number, but not as a string.
+	anna99 := Name{Name: "Anna", ID: "Q99"}
+	if got := NameIsLess(anna99, anna); got != true {
+		t.Errorf("got NameIsLess(anna99, anna) == %v", got)
+	}
+	annaAlias := Name{Name: "Anna", ID: "Q123", Source: "alias"}
+	annaLabel := Name{Name: "Anna", ID: "Q123", Source: "label"}
+	if got := NameIsLess(annaAlias, annaLabel); got != true {
+		t.Errorf("got NameIsLess(annaAlias, annaLabel) == %v", got)
+	}
 }
 
 func TestNameWriter(t *testing.T) {
@@ -35,15 +78,29 @@ func TestNameWriter(t *testing.T) {
 		return
 	}
 
-	if err := w.WriteName(&Name{"Wilde", "Q21050435"}); err != nil {
+	if err := w.WriteName(&Name{
+		Name:           "Wilde",
+		ID:             "Q21050435",
+		Languages:      []string{"en"},
+		Source:         "label",
+		Scripts:        []string{"Latn"},
+		WritingSystems: []string{"Q8229"},
+		Classes:        []string{"Q101352"},
+		Folded:         "wilde",
+		Bearers:        38,
+	}); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := w.WriteName(&Name{Name: "Bechdel", ID: "Q4878552", Languages: []string{"de", "en"}, Source: "alias"}); err != nil {
 		t.Error(err)
 		return
 	}
-	if err := w.WriteName(&Name{"Bechdel", "Q4878552"}); err != nil {
+	if err := w.WriteName(&Name{Name: "De Beauvoir", ID: "Q104591741", Source: "label"}); err != nil {
 		t.Error(err)
 		return
 	}
-	if err := w.WriteName(&Name{"De Beauvoir", "Q104591741"}); err != nil {
+	if err := w.WriteName(&Name{Name: "Bechdel", ID: "Q4878552", Languages: []string{"de", "en"}, Source: "alias"}); err != nil {
 		t.Error(err)
 		return
 	}
@@ -61,11 +118,128 @@ func TestNameWriter(t *testing.T) {
 	}
 
 	got := string(buf.Bytes())
-	want := ("Name,WikidataID\n" +
-		"Bechdel,Q4878552\n" +
-		"De Beauvoir,Q104591741\n" +
-		"Wilde,Q21050435\n")
+	want := ("Name,WikidataID,Languages,Source,Scripts,WritingSystems,Classes,FoldedName,Bearers\n" +
+		"Bechdel,Q4878552,de;en,alias,,,,,0\n" +
+		"De Beauvoir,Q104591741,,label,,,,,0\n" +
+		"Wilde,Q21050435,en,label,Latn,Q8229,Q101352,wilde,38\n")
 	if got != want {
 		t.Errorf("got %s, want %s", got, want)
 	}
 }
+
+func TestNameWriterConcurrent(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewNameWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const producers, perProducer = 8, 500
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < perProducer; i++ {
+				n := Name{Name: fmt.Sprintf("N%d-%d", i, p), ID: fmt.Sprintf("Q%d", p)}
+				if err := w.WriteName(&n); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 1+producers*perProducer {
+		t.Fatalf("got %d lines, want %d", len(lines), 1+producers*perProducer)
+	}
+	if !sort.StringsAreSorted(lines[1:]) {
+		t.Error("output not sorted")
+	}
+
+	if err := w.WriteName(&Name{Name: "Late"}); err == nil {
+		t.Error("expected error when writing to closed NameWriter")
+	}
+}
+
+// Sorting must not depend on how extsort splits its input into chunks,
+// or else weekly extracts would differ without any change in Wikidata.
+func TestNameWriterChunkSize(t *testing.T) {
+	stream, err := os.Open(filepath.Join("testdata", "full", "entities.json.bz2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	var entities []mediawiki.Entity
+	if err := json.NewDecoder(bzip2.NewReader(stream)).Decode(&entities); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ReadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []Name
+	for i := range entities {
+		n, _ := EntityNames(&entities[i], config.Outputs[0].Sources, nil)
+		names = append(names, n...)
+	}
+
+	// Same spelling for different items, and an exact duplicate.
+	names = append(names,
+		Name{Name: "Ivar", ID: "Q21050435", Source: "label"},
+		Name{Name: "Ivar", ID: "Q99", Source: "label"},
+		Name{Name: "Ivar", ID: "Q99", Source: "label"},
+	)
+
+	var want string
+	for i, chunkSize := range []int{0, 2, 3, 17} {
+		shuffled := slices.Clone(names)
+		rand.New(rand.NewPCG(uint64(i), 0)).Shuffle(len(shuffled), func(a, b int) {
+			shuffled[a], shuffled[b] = shuffled[b], shuffled[a]
+		})
+
+		var buf bytes.Buffer
+		w, err := newNameWriter(&buf, &extsort.Config{ChunkSize: chunkSize})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, n := range shuffled {
+			if err := w.WriteName(&n); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		got := buf.String()
+		if i == 0 {
+			want = got
+		} else if got != want {
+			t.Errorf("chunk size %d: got %q, want %q", chunkSize, got, want)
+		}
+	}
+
+	if strings.Count(want, "\nIvar,Q99,") != 1 {
+		t.Errorf("expected duplicates to be dropped, got %q", want)
+	}
+	if !strings.Contains(want, "\nIvar,Q99,,label,,,,,0\nIvar,Q127069,") {
+		t.Errorf("expected Q99 before Q127069, got %q", want)
+	}
+}
+
+func TestSortConfig(t *testing.T) {
+	if got := sortConfig(3).NumWorkers; got != 3 {
+		t.Errorf("got %d workers, want 3", got)
+	}
+	if got := sortConfig(0).NumWorkers; got != runtime.GOMAXPROCS(0) {
+		t.Errorf("got %d workers, want one per CPU", got)
+	}
+}
@@ -0,0 +1,80 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizeName cleans up a name as typed into Wikidata. The result is
+// in Unicode normalization form NFC, has no leading or trailing space,
+// and every run of whitespace inside is collapsed into a single space.
+// Invisible characters that are only there by accident, such as the
+// zero-width space, get removed. The zero-width joiner and non-joiner
+// get kept inside names because they matter for the rendering of some
+// scripts, such as Persian or Devanagari, but they get trimmed at the
+// start and end.
+func NormalizeName(s string) string {
+	s = strings.TrimFunc(norm.NFC.String(s), func(c rune) bool {
+		return unicode.IsSpace(c) || isJoiner(c) || isInvisible(c)
+	})
+	var buf strings.Builder
+	buf.Grow(len(s))
+	space := false
+	for _, c := range s {
+		switch {
+		case isInvisible(c):
+			continue
+		case unicode.IsSpace(c):
+			space = true
+			continue
+		}
+		if space {
+			buf.WriteByte(' ')
+		}
+		space = false
+		buf.WriteRune(c)
+	}
+	return buf.String()
+}
+
+// isJoiner tells whether a character is the zero-width non-joiner
+// or the zero-width joiner.
+func isJoiner(c rune) bool {
+	return c == '\u200c' || c == '\u200d'
+}
+
+// isInvisible tells whether a character is invisible and gets removed
+// by NormalizeName: the zero-width space, the word joiner, and the
+// zero-width no-break space, which is also used as byte order mark.
+func isInvisible(c rune) bool {
+	return c == '\u200b' || c == '\u2060' || c == '\ufeff'
+}
+
+// FoldName returns a key for matching names regardless of case and
+// diacritics, such as "muller" for "Müller". The key is meant for
+// joining, not for display; for example, "Straße" becomes "strasse".
+// Diacritics only get removed from Latin, Greek and Cyrillic letters;
+// in other scripts, such as Devanagari, combining marks are needed
+// to spell the vowels.
+func FoldName(s string) string {
+	var buf strings.Builder
+	buf.Grow(len(s))
+	strip := false
+	for _, c := range norm.NFD.String(s) {
+		if unicode.Is(unicode.Mn, c) {
+			if strip {
+				continue
+			}
+		} else {
+			strip = unicode.In(c, unicode.Latin, unicode.Greek, unicode.Cyrillic)
+		}
+		buf.WriteRune(c)
+	}
+	return cases.Fold().String(norm.NFC.String(buf.String()))
+}
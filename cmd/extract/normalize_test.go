@@ -0,0 +1,54 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"testing"
+)
+
+func TestNormalizeName(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		want string
+	}{
+		{"", ""},
+		{"Weiss", "Weiss"},
+		{"  Weiss\t", "Weiss"},
+		{"De   Beauvoir", "De Beauvoir"},
+		{"Mu\u0308ller", "Müller"},
+		{"Mül\u200bler\ufeff", "Müller"},
+		{"\u200dمی\u200cخواهم\u200c", "می\u200cخواهم"},
+		{" \u200b ", ""},
+		{"A \u200c", "A"},
+		{"\u200c A", "A"},
+		{"\u200d \u200b\u200c Weiss \u2060\u200d ", "Weiss"},
+		{"A \u200c B", "A \u200c B"},
+	} {
+		if got := NormalizeName(tc.name); got != tc.want {
+			t.Errorf("NormalizeName(%q) = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestFoldName(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		want string
+	}{
+		{"", ""},
+		{"Müller", "muller"},
+		{"Mu\u0308ller", "muller"},
+		{"MÜLLER", "muller"},
+		{"Straße", "strasse"},
+		{"Ðorđević", "ðorđevic"},
+		{"Вайс", "ваис"},
+		{"Ἀθηνᾶ", "αθηνα"},
+		{"कुमार", "कुमार"},
+		{"魏斯", "魏斯"},
+	} {
+		if got := FoldName(tc.name); got != tc.want {
+			t.Errorf("FoldName(%q) = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// ParquetName is a row in the Parquet output. Unlike in the CSV file,
+// Wikidata IDs are integers, so Q167755 becomes 167755. Types holds
+// the most specific matched classes, such as 11879590 for
+// Q11879590 (female given name). Folded is the key for matching,
+// such as "muller" for "Müller". Bearers is the number of humans
+// with the name.
+type ParquetName struct {
+	Name      string   `parquet:"name"`
+	QID       int64    `parquet:"qid"`
+	Languages []string `parquet:"languages,list"`
+	Source    string   `parquet:"source,dict"`
+	Types     []int64  `parquet:"types,list"`
+	Folded    string   `parquet:"folded"`
+	Bearers   int64    `parquet:"bearers"`
+}
+
+// ParquetWriter is a NameSink that writes names into an Apache Parquet
+// file. Because NameWriter passes names in sorted order, every row
+// group is sorted by name, which we declare in the file metadata
+// so that query engines can skip row groups.
+type ParquetWriter struct {
+	file   *os.File
+	writer *parquet.GenericWriter[ParquetName]
+	batch  []ParquetName
+	closed bool
+}
+
+func NewParquetWriter(path string) (*ParquetWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	writer := parquet.NewGenericWriter[ParquetName](file,
+		parquet.Compression(&parquet.Zstd),
+		parquet.MaxRowsPerRowGroup(1000000),
+		parquet.SortingWriterConfig(
+			parquet.SortingColumns(parquet.Ascending("name")),
+		),
+	)
+
+	return &ParquetWriter{
+		file:   file,
+		writer: writer,
+		batch:  make([]ParquetName, 0, 1000),
+	}, nil
+}
+
+func (w *ParquetWriter) WriteName(n *Name) error {
+	qid, err := parseQID(n.ID)
+	if err != nil {
+		return err
+	}
+
+	types := make([]int64, 0, len(n.Classes))
+	for _, c := range n.Classes {
+		t, err := parseQID(c)
+		if err != nil {
+			return err
+		}
+		types = append(types, t)
+	}
+
+	w.batch = append(w.batch, ParquetName{
+		Name:      n.Name,
+		QID:       qid,
+		Languages: n.Languages,
+		Source:    n.Source,
+		Types:     types,
+		Folded:    n.Folded,
+		Bearers:   n.Bearers,
+	})
+	if len(w.batch) == cap(w.batch) {
+		return w.flush()
+	}
+	return nil
+}
+
+func (w *ParquetWriter) flush() error {
+	if _, err := w.writer.Write(w.batch); err != nil {
+		return err
+	}
+	w.batch = w.batch[:0]
+	return nil
+}
+
+func (w *ParquetWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	if err := w.flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	if err := w.writer.Close(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// parseQID returns the numeric part of a Wikidata item ID,
+// such as 167755 for "Q167755".
+func parseQID(id string) (int64, error) {
+	if !strings.HasPrefix(id, "Q") {
+		return 0, fmt.Errorf("bad Wikidata ID: %q", id)
+	}
+	qid, err := strconv.ParseInt(id[1:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad Wikidata ID: %q", id)
+	}
+	return qid, nil
+}
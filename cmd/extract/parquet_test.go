@@ -0,0 +1,72 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestParquetWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "names.parquet")
+	p, err := NewParquetWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewNameWriter(io.Discard, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []Name{
+		{Name: "Wilde", ID: "Q21050435", Languages: []string{"en"}, Source: "label", Classes: []string{"Q101352"}, Folded: "wilde", Bearers: 38},
+		{Name: "Astrid", ID: "Q167755", Languages: []string{"de", "sv"}, Source: "label", Classes: []string{"Q11879590"}},
+		{Name: "Bechdel", ID: "Q4878552", Source: "alias"},
+	} {
+		if err := w.WriteName(&n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := parquet.ReadFile[ParquetName](path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ParquetName{
+		{Name: "Astrid", QID: 167755, Languages: []string{"de", "sv"}, Source: "label", Types: []int64{11879590}},
+		{Name: "Bechdel", QID: 4878552, Languages: []string{}, Source: "alias", Types: []int64{}},
+		{Name: "Wilde", QID: 21050435, Languages: []string{"en"}, Source: "label", Types: []int64{101352}, Folded: "wilde", Bearers: 38},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pf, err := parquet.OpenFile(f, info.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rg := range pf.RowGroups() {
+		sorting := rg.SortingColumns()
+		if len(sorting) != 1 || sorting[0].Path()[0] != "name" || sorting[0].Descending() {
+			t.Errorf("row group should be sorted by name, got %v", sorting)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lanrat/extsort"
+	"gitlab.com/tozd/go/mediawiki"
+	"golang.org/x/sync/errgroup"
+)
+
+// personsName is the name of the persons file and of its spool.
+// Like rejectedName, it is reserved and cannot name an output.
+const personsName = "persons"
+
+// personsHeader returns the CSV header of the persons file. There is
+// one row for each human in each language of its labels. For each
+// output with bearers, such as "givennames", there are two columns.
+// The first lists the human's name items in order, such as
+// "Q167755;Q127069" for the given names of "Astrid Ivar Weiss".
+// The second, such as "givennamesLabels", lists their spellings
+// in the row's language, such as "Astrid;Ivar".
+func personsHeader(outputs []*Output) []string {
+	header := []string{"WikidataID", "Language", "Label"}
+	for _, o := range outputs {
+		header = append(header, o.name, o.name+"Labels")
+	}
+	return header
+}
+
+// personsPath returns the path of the persons file,
+// such as "persons-20230418.csv.gz" in the working directory.
+func personsPath(workdir string, date time.Time) string {
+	return extractPath(workdir, personsName, date)
+}
+
+// person is what gets spooled about a human. Parts has one entry for
+// each output with bearers, listing the name items in their order.
+type person struct {
+	Labels map[string]string `json:"labels"`
+	Parts  [][]int64         `json:"parts"`
+}
+
+// Persons collects how humans combine the names of the outputs that
+// count bearers, such as given and family names. It spools each human
+// while the dump is being processed, and Close writes the persons file
+// with the name items resolved to their labels.
+type Persons struct {
+	workdir string
+	date    time.Time
+	outputs []*Output
+	mutex   sync.Mutex
+	spool   *Spool
+}
+
+// NewPersons returns a Persons for the outputs that have bearers.
+func NewPersons(workdir string, date time.Time, outputs []*Output) (*Persons, error) {
+	spool, err := OpenSpool(spoolPath(workdir, personsName, date))
+	if err != nil {
+		return nil, err
+	}
+	p := &Persons{workdir: workdir, date: date, spool: spool}
+	for _, o := range outputs {
+		if o.config.Bearers != "" {
+			p.outputs = append(p.outputs, o)
+		}
+	}
+	return p, nil
+}
+
+// Write spools a human, unless it has no labels or no names.
+// It is safe to call Write from multiple goroutines.
+func (p *Persons) Write(e *mediawiki.Entity) error {
+	qid, err := parseQID(e.ID)
+	if err != nil {
+		return nil
+	}
+
+	rec := person{
+		Labels: make(map[string]string, len(e.Labels)),
+		Parts:  make([][]int64, len(p.outputs)),
+	}
+	for lang, label := range e.Labels {
+		if name := NormalizeName(label.Value); name != "" {
+			rec.Labels[lang] = name
+		}
+	}
+	hasNames := false
+	for i, o := range p.outputs {
+		rec.Parts[i] = NameParts(e, o.config.Bearers)
+		hasNames = hasNames || len(rec.Parts[i]) > 0
+	}
+	if len(rec.Labels) == 0 || !hasNames {
+		return nil
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.spool.Write(itemLine{qid, data}.ToBytes())
+}
+
+// NameParts returns the items that an entity's best claims for a
+// property, such as "given name" (P735), point to. They are ordered
+// by their "series ordinal" (P1545) qualifiers; claims without one
+// come last, in the order of the dump. A claim that repeats an earlier
+// one, with the same item and ordinal, is dropped.
+func NameParts(e *mediawiki.Entity, prop string) []int64 {
+	type part struct{ qid, ordinal int64 }
+	var parts []part
+	for _, claim := range BestClaims(e, prop) {
+		snak := claim.MainSnak
+		if snak.SnakType != mediawiki.Value || snak.DataValue == nil {
+			continue
+		}
+		val, ok := snak.DataValue.Value.(mediawiki.WikiBaseEntityIDValue)
+		if !ok {
+			continue
+		}
+		qid, err := parseQID(val.ID)
+		if err != nil {
+			continue
+		}
+		p := part{qid, math.MaxInt64}
+		for _, q := range claim.Qualifiers["P1545"] {
+			if q.SnakType != mediawiki.Value || q.DataValue == nil {
+				continue
+			}
+			if s, ok := q.DataValue.Value.(mediawiki.StringValue); ok {
+				if n, err := strconv.ParseInt(string(s), 10, 64); err == nil {
+					p.ordinal = n
+					break
+				}
+			}
+		}
+		if !slices.Contains(parts, p) {
+			parts = append(parts, p)
+		}
+	}
+	slices.SortStableFunc(parts, func(a, b part) int {
+		return cmp.Compare(a.ordinal, b.ordinal)
+	})
+
+	result := make([]int64, 0, len(parts))
+	for _, p := range parts {
+		result = append(result, p.qid)
+	}
+	return result
+}
+
+// Sync writes the spooled humans to stable storage and records
+// the size of the spool file in a checkpoint.
+func (p *Persons) Sync(cp *Checkpoint) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	size, err := p.spool.Sync()
+	if err != nil {
+		return err
+	}
+	cp.Spools[personsName] = size
+	return nil
+}
+
+// Truncate drops everything that got spooled after a checkpoint.
+func (p *Persons) Truncate(cp *Checkpoint) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.spool.Truncate(cp.Spools[personsName])
+}
+
+// Close writes the persons file, sorted by numeric Wikidata ID and
+// language, and returns its number of rows. The names are taken from
+// the labels in the extracts, which must have been written already.
+// If a name item has no label in the language of a human's label,
+// its multilingual ("mul") label is used, or else the name is empty.
+// The spool file is kept; callers should call Remove once done.
+func (p *Persons) Close() (int64, error) {
+	labels, err := p.nameLabels()
+	if err != nil {
+		return 0, err
+	}
+
+	writer, err := newCSVFileWriter(personsPath(p.workdir, p.date), personsHeader(p.outputs))
+	if err != nil {
+		return 0, err
+	}
+	defer writer.Abort()
+
+	var rows int64
+	sortChan := make(chan extsort.SortType, 10000)
+	sorter, outChan, errChan := extsort.New(sortChan, itemLineFromBytes, itemLineIsLess, nil)
+	task, ctx := errgroup.WithContext(context.Background())
+	task.Go(func() error {
+		sorter.Sort(ctx)
+		return nil
+	})
+	task.Go(func() error {
+		for l := range outChan {
+			line := l.(itemLine)
+			var rec person
+			if err := json.Unmarshal(line.line, &rec); err != nil {
+				return err
+			}
+			n, err := writeRows(writer, line.qid, &rec, labels)
+			if err != nil {
+				return err
+			}
+			rows += n
+		}
+		return <-errChan
+	})
+
+	err = p.spool.ReadAll(func(record []byte) error {
+		select {
+		case sortChan <- itemLineFromBytes(record):
+			return nil
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	})
+	close(sortChan)
+	if taskErr := task.Wait(); err == nil {
+		err = taskErr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return 0, err
+	}
+	return rows, p.spool.Close()
+}
+
+// writeRows writes the rows for one human, and returns their number.
+// Labels holds the labels of the name items, keyed by numeric Wikidata
+// ID and language.
+func writeRows(w recordWriter, qid int64, rec *person, labels map[int64]map[string]string) (int64, error) {
+	langs := make([]string, 0, len(rec.Labels))
+	for lang := range rec.Labels {
+		langs = append(langs, lang)
+	}
+	slices.Sort(langs)
+
+	ids := make([]string, len(rec.Parts))
+	for i, parts := range rec.Parts {
+		partIDs := make([]string, len(parts))
+		for j, part := range parts {
+			partIDs[j] = fmt.Sprintf("Q%d", part)
+		}
+		ids[i] = strings.Join(partIDs, ";")
+	}
+
+	id := fmt.Sprintf("Q%d", qid)
+	for _, lang := range langs {
+		record := []string{id, lang, rec.Labels[lang]}
+		for i, parts := range rec.Parts {
+			names := make([]string, len(parts))
+			for j, part := range parts {
+				name, ok := labels[part][lang]
+				if !ok {
+					name = labels[part]["mul"]
+				}
+				names[j] = name
+			}
+			record = append(record, ids[i], strings.Join(names, ";"))
+		}
+		if err := w.Write(record); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(langs)), nil
+}
+
+// nameLabels returns the labels of the name items in the extracts,
+// keyed by numeric Wikidata ID and language.
+func (p *Persons) nameLabels() (map[int64]map[string]string, error) {
+	labels := make(map[int64]map[string]string, 100000)
+	for _, o := range p.outputs {
+		r, err := openExtractReader(extractPath(o.workdir, o.name, o.date))
+		if err != nil {
+			return nil, err
+		}
+		for {
+			group, err := r.ReadGroup()
+			if err != nil {
+				r.Close()
+				return nil, err
+			}
+			if len(group) == 0 {
+				break
+			}
+			for _, n := range group {
+				qid, err := parseQID(n.ID)
+				if err != nil || n.Source != "label" {
+					continue
+				}
+				if labels[qid] == nil {
+					labels[qid] = make(map[string]string, len(n.Languages))
+				}
+				for _, lang := range n.Languages {
+					labels[qid][lang] = n.Name
+				}
+			}
+		}
+		if err := r.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return labels, nil
+}
+
+// Remove deletes the spool file.
+func (p *Persons) Remove() error {
+	return p.spool.Remove()
+}
@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"slices"
+	"testing"
+
+	"gitlab.com/tozd/go/mediawiki"
+)
+
+func TestNameParts(t *testing.T) {
+	part := func(qid string, ordinal string) mediawiki.Statement {
+		s := claim(mediawiki.WikiBaseEntityIDValue{ID: qid})
+		if ordinal != "" {
+			s.Qualifiers = map[string][]mediawiki.Snak{"P1545": {{
+				SnakType:  mediawiki.Value,
+				DataValue: &mediawiki.DataValue{Value: mediawiki.StringValue(ordinal)},
+			}}}
+		}
+		return s
+	}
+	e := mediawiki.Entity{Claims: map[string][]mediawiki.Statement{
+		"P735": {
+			part("Q7", ""),
+			part("Q3", "2"),
+			part("Q9", "x"),
+			part("Q5", "1"),
+			part("Q7", ""),
+			part("Q3", "10"),
+		},
+	}}
+	got := NameParts(&e, "P735")
+	want := []int64{5, 3, 3, 7, 9}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := NameParts(&e, "P734"); len(got) != 0 {
+		t.Errorf("got %v for missing property, want none", got)
+	}
+}
+
+func TestPersonsWriteRows(t *testing.T) {
+	rec := person{
+		Labels: map[string]string{"sv": "Astrid Ivar Weiss", "hu": "Weiss Astrid Ivar"},
+		Parts:  [][]int64{{145210}, {167755, 127069}},
+	}
+	labels := map[int64]map[string]string{
+		145210: {"mul": "Weiss", "hu": "Weiß"},
+		167755: {"sv": "Astrid"},
+		127069: {"mul": "Ivar"},
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	rows, err := writeRows(w, 90000002, &rec, labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Flush()
+	want := "Q90000002,hu,Weiss Astrid Ivar,Q145210,Weiß,Q167755;Q127069,;Ivar\n" +
+		"Q90000002,sv,Astrid Ivar Weiss,Q145210,Weiss,Q167755;Q127069,Astrid;Ivar\n"
+	if rows != 2 || buf.String() != want {
+		t.Errorf("got %d rows %q, want %q", rows, buf.String(), want)
+	}
+}
+
+func TestExtractorPersons(t *testing.T) {
+	workdir := t.TempDir()
+	m := runFullExtract(t, workdir, nil)
+
+	// Fictional humans are not persons, and repeated names count once.
+	// Given names are ordered by their series ordinal (P1545), and
+	// spelled in the language of the human's label.
+	got, err := readExtract(personsPath(workdir, fullDumpDate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "WikidataID,Language,Label,familynames,familynamesLabels,givennames,givennamesLabels\n" +
+		"Q90000001,en,Ivar Weiss,Q145210,Weiss,Q127069,Ivar\n" +
+		"Q90000002,en,Astrid Ivar Weiss,Q145210,Weiss,Q167755;Q127069,Astrid;Ivar\n" +
+		"Q90000002,hu,Weiss Astrid Ivar,Q145210,Weiss,Q167755;Q127069,Astrid;Ivar\n" +
+		"Q90000002,ru,Астрид Ивар Вайс,Q145210,Вайс,Q167755;Q127069,Астрид;Ивар\n" +
+		"Q90000004,en,Ivar,,,Q127069,Ivar\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if m.Persons != 5 || m.PersonsFile == nil || m.PersonsFile.Name != "persons-20230418.csv.gz" {
+		t.Errorf("got %d persons in %v", m.Persons, m.PersonsFile)
+	}
+}
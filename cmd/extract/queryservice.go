@@ -0,0 +1,220 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// QueryService finds subclasses by asking the Wikidata Query Service.
+//
+// Requests that fail with a transient error, such as HTTP status 429
+// (too many requests) or 503 (service unavailable), get retried with
+// exponential backoff, honoring any Retry-After header sent by the
+// server. If CacheDir is set, the result of every successful query is
+// stored there, and used as fallback when the query service is down.
+// Falling back gets reported to Log, which the extractor sets to its own,
+// and so do failures to store a result, which do not fail the query.
+type QueryService struct {
+	Endpoint   string
+	UserAgent  string
+	Timeout    time.Duration // per attempt
+	MaxRetries int
+	CacheDir   string
+	Log        io.Writer
+
+	client *http.Client
+	sleep  func(time.Duration)
+}
+
+func NewQueryService(client *http.Client, cacheDir string) *QueryService {
+	return &QueryService{
+		Endpoint:   "https://query.wikidata.org/sparql",
+		UserAgent:  "WikidataNamesBot/1.0",
+		Timeout:    5 * time.Minute,
+		MaxRetries: 5,
+		CacheDir:   cacheDir,
+		Log:        os.Stderr,
+		client:     client,
+		sleep:      time.Sleep,
+	}
+}
+
+// setSubclasserLog directs the reports of a subclasser to a log,
+// if the subclasser makes any.
+func setSubclasserLog(s Subclasser, log io.Writer) {
+	if q, ok := s.(*QueryService); ok {
+		q.Log = log
+	}
+}
+
+func (q *QueryService) Subclasses(classID int64) (ClassSet, error) {
+	body, err := q.querySubclasses(classID)
+	if err == nil {
+		var cset ClassSet
+		if cset, err = parseSubclasses(classID, bytes.NewReader(body)); err == nil {
+			if err := q.writeCache(classID, body); err != nil {
+				fmt.Fprintf(q.Log, "cannot cache subclasses of Q%d: %v\n", classID, err)
+			}
+			return cset, nil
+		}
+	}
+
+	if q.CacheDir == "" {
+		return nil, err
+	}
+	cached, cacheErr := os.Open(q.cachePath(classID))
+	if cacheErr != nil {
+		return nil, err
+	}
+	defer cached.Close()
+	fmt.Fprintf(q.Log, "%s; using cached subclasses of Q%d\n", err, classID)
+	return parseSubclasses(classID, cached)
+}
+
+// Sends the SPARQL query, retrying on transient errors. On success,
+// the returned response body is still unparsed CSV.
+func (q *QueryService) querySubclasses(classID int64) ([]byte, error) {
+	query := fmt.Sprintf(
+		"SELECT ?subclass WHERE {?subclass wdt:P279* wd:Q%d. }",
+		classID)
+	queryUrl := q.Endpoint + "?query=" + url.QueryEscape(query)
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		var body []byte
+		var retryAfter time.Duration
+		body, retryAfter, err = q.fetch(queryUrl)
+		if err == nil {
+			return body, nil
+		}
+		if retryAfter < 0 || attempt >= q.MaxRetries {
+			return nil, err
+		}
+		if retryAfter == 0 {
+			retryAfter = time.Second << attempt
+		}
+		q.sleep(retryAfter)
+	}
+}
+
+// Fetches a URL once. If the request has failed but is worth retrying,
+// the returned duration is zero, or the delay requested by the server.
+// For permanent errors, the returned duration is negative.
+func (q *QueryService) fetch(queryUrl string) ([]byte, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), q.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", queryUrl, nil)
+	if err != nil {
+		return nil, -1, err
+	}
+	req.Header.Add("Accept", "text/csv")
+	req.Header.Add("User-Agent", q.UserAgent)
+
+	resp, err := q.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("%s: %s", q.Endpoint, resp.Status)
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			return nil, parseRetryAfter(resp.Header.Get("Retry-After")), err
+		}
+		return nil, -1, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	return body, 0, nil
+}
+
+// Parses the value of a Retry-After header, which can be given either
+// in seconds or as HTTP date. Returns zero if the value is missing or
+// cannot be parsed. Overly long delays get capped.
+func parseRetryAfter(value string) time.Duration {
+	const maxDelay = 10 * time.Minute
+	var delay time.Duration
+	if secs, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+		delay = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(value); err == nil {
+		delay = time.Until(t)
+	}
+	if delay < 0 {
+		return 0
+	}
+	if delay > maxDelay {
+		return maxDelay
+	}
+	return delay
+}
+
+// Parses the CSV response to our SPARQL query. Unlike the CSV parser,
+// we reject anything that does not look like a query result, such as
+// HTML error pages. Since every class is its own subclass, a result
+// without rows means that the query service has failed silently.
+func parseSubclasses(classID int64, r io.Reader) (ClassSet, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("bad subclasses of Q%d: %w", classID, err)
+	}
+	if len(header) != 1 || header[0] != "subclass" {
+		return nil, fmt.Errorf("bad subclasses of Q%d: unexpected header %q", classID, header)
+	}
+
+	cset := make(ClassSet, 500)
+	cset[classID] = struct{}{}
+	for rows := 0; ; rows++ {
+		record, err := reader.Read()
+		if err == io.EOF && rows == 0 {
+			return nil, fmt.Errorf("bad subclasses of Q%d: no rows", classID)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("bad subclasses of Q%d: %w", classID, err)
+		}
+		const prefix = "http://www.wikidata.org/entity/Q"
+		if len(record) == 1 && strings.HasPrefix(record[0], prefix) {
+			val, err := strconv.ParseInt(record[0][len(prefix):], 10, 64)
+			if err == nil {
+				cset[val] = struct{}{}
+			}
+		}
+	}
+
+	return cset, nil
+}
+
+func (q *QueryService) cachePath(classID int64) string {
+	return filepath.Join(q.CacheDir, fmt.Sprintf("subclasses-Q%d.csv", classID))
+}
+
+func (q *QueryService) writeCache(classID int64, body []byte) error {
+	if q.CacheDir == "" {
+		return nil
+	}
+	path := q.cachePath(classID)
+	if err := os.WriteFile(path+".tmp", body, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,205 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuerySubclasses(t *testing.T) {
+	var userAgent string
+	client := NewTestClient(func(req *http.Request) *http.Response {
+		userAgent = req.Header.Get("User-Agent")
+		if req.URL.Host != "sparql.example.org" {
+			t.Errorf("unexpected query service %q", req.URL.Host)
+		}
+		return csvResponse(http.StatusOK, "subclass\n"+
+			"http://www.wikidata.org/entity/Q123\n"+
+			"http://www.wikidata.org/entity/Q987\n")
+	})
+
+	q := NewQueryService(client, "")
+	q.Endpoint = "https://sparql.example.org/sparql"
+	q.UserAgent = "TestBot/2.0"
+	gotSet, err := q.Subclasses(777)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := formatClassSet(gotSet)
+	want := "Q123,Q777,Q987"
+	if got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if userAgent != "TestBot/2.0" {
+		t.Errorf("got User-Agent %q, want %q", userAgent, "TestBot/2.0")
+	}
+}
+
+func TestQuerySubclassesRetry(t *testing.T) {
+	responses := []*http.Response{
+		csvResponse(http.StatusTooManyRequests, "slow down"),
+		csvResponse(http.StatusServiceUnavailable, "<html>down</html>"),
+		csvResponse(http.StatusOK, "subclass\nhttp://www.wikidata.org/entity/Q123\n"),
+	}
+	responses[0].Header.Set("Retry-After", "7")
+	client := NewTestClient(func(req *http.Request) *http.Response {
+		resp := responses[0]
+		responses = responses[1:]
+		return resp
+	})
+
+	var sleeps []time.Duration
+	q := NewQueryService(client, "")
+	q.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	gotSet, err := q.Subclasses(777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := formatClassSet(gotSet); got != "Q123,Q777" {
+		t.Errorf("got %v, want Q123,Q777", got)
+	}
+
+	want := []time.Duration{7 * time.Second, 2 * time.Second}
+	if len(sleeps) != len(want) || sleeps[0] != want[0] || sleeps[1] != want[1] {
+		t.Errorf("got sleeps %v, want %v", sleeps, want)
+	}
+}
+
+func TestQuerySubclassesPermanentError(t *testing.T) {
+	calls := 0
+	client := NewTestClient(func(req *http.Request) *http.Response {
+		calls += 1
+		return csvResponse(http.StatusBadRequest, "bad query")
+	})
+
+	q := NewQueryService(client, "")
+	q.sleep = func(d time.Duration) { t.Errorf("unexpected retry") }
+	if _, err := q.Subclasses(777); err == nil {
+		t.Error("expected error, got nil")
+	}
+	if calls != 1 {
+		t.Errorf("got %d calls, want 1", calls)
+	}
+}
+
+func TestQuerySubclassesErrorPage(t *testing.T) {
+	client := NewTestClient(func(req *http.Request) *http.Response {
+		return csvResponse(http.StatusOK, "<html><body>Oops</body></html>\n")
+	})
+
+	q := NewQueryService(client, "")
+	if _, err := q.Subclasses(777); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+func TestQuerySubclassesNoRows(t *testing.T) {
+	client := NewTestClient(func(req *http.Request) *http.Response {
+		return csvResponse(http.StatusOK, "subclass\n")
+	})
+
+	q := NewQueryService(client, "")
+	if _, err := q.Subclasses(777); err == nil || !strings.Contains(err.Error(), "no rows") {
+		t.Errorf("got %v, want error about missing rows", err)
+	}
+}
+
+func TestQuerySubclassesCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	down := false
+	client := NewTestClient(func(req *http.Request) *http.Response {
+		if down {
+			return csvResponse(http.StatusInternalServerError, "down")
+		}
+		return csvResponse(http.StatusOK, "subclass\nhttp://www.wikidata.org/entity/Q123\n")
+	})
+
+	var log bytes.Buffer
+	q := NewQueryService(client, cacheDir)
+	q.Log = &log
+	q.sleep = func(d time.Duration) {}
+	if _, err := q.Subclasses(777); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, "subclasses-Q777.csv")); err != nil {
+		t.Error(err)
+	}
+
+	down = true
+	gotSet, err := q.Subclasses(777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := formatClassSet(gotSet); got != "Q123,Q777" {
+		t.Errorf("got %v, want Q123,Q777", got)
+	}
+	if !strings.Contains(log.String(), "using cached subclasses of Q777") {
+		t.Errorf("got log %q", log.String())
+	}
+
+	// Without a cached result, the error should be passed to the caller.
+	if _, err := q.Subclasses(888); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+// A query that succeeds should not fail because its result
+// cannot be stored in the cache.
+func TestQuerySubclassesCacheWriteError(t *testing.T) {
+	client := NewTestClient(func(req *http.Request) *http.Response {
+		return csvResponse(http.StatusOK, "subclass\nhttp://www.wikidata.org/entity/Q123\n")
+	})
+
+	var log bytes.Buffer
+	q := NewQueryService(client, filepath.Join(t.TempDir(), "missing"))
+	q.Log = &log
+	gotSet, err := q.Subclasses(777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := formatClassSet(gotSet); got != "Q123,Q777" {
+		t.Errorf("got %v, want Q123,Q777", got)
+	}
+	if !strings.Contains(log.String(), "cannot cache subclasses of Q777") {
+		t.Errorf("got log %q", log.String())
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"junk", 0},
+		{"12", 12 * time.Second},
+		{"-3", 0},
+		{"86400", 10 * time.Minute},
+		{"Wed, 21 Oct 2015 07:28:00 GMT", 0},
+	} {
+		if got := parseRetryAfter(tc.value); got != tc.want {
+			t.Errorf("parseRetryAfter(%q): got %v, want %v", tc.value, got, tc.want)
+		}
+	}
+}
+
+func csvResponse(status int, body string) *http.Response {
+	var buf bytes.Buffer
+	buf.WriteString(body)
+	return &http.Response{
+		StatusCode: status,
+		Status:     strings.TrimSpace(http.StatusText(status)),
+		Header:     make(http.Header),
+		Body:       io.NopCloser(&buf),
+	}
+}
@@ -0,0 +1,289 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"sort"
+	"unicode"
+)
+
+// ISO 15924 codes, keyed by the script names of Go's unicode package.
+// Scripts that are missing from the unicode tables of the Go release
+// used for building get silently skipped.
+var scriptCodes = map[string]string{
+	"Adlam":                  "Adlm",
+	"Ahom":                   "Ahom",
+	"Anatolian_Hieroglyphs":  "Hluw",
+	"Arabic":                 "Arab",
+	"Armenian":               "Armn",
+	"Avestan":                "Avst",
+	"Balinese":               "Bali",
+	"Bamum":                  "Bamu",
+	"Bassa_Vah":              "Bass",
+	"Batak":                  "Batk",
+	"Bengali":                "Beng",
+	"Beria_Erfe":             "Berf",
+	"Bhaiksuki":              "Bhks",
+	"Bopomofo":               "Bopo",
+	"Brahmi":                 "Brah",
+	"Braille":                "Brai",
+	"Buginese":               "Bugi",
+	"Buhid":                  "Buhd",
+	"Canadian_Aboriginal":    "Cans",
+	"Carian":                 "Cari",
+	"Caucasian_Albanian":     "Aghb",
+	"Chakma":                 "Cakm",
+	"Cham":                   "Cham",
+	"Cherokee":               "Cher",
+	"Chorasmian":             "Chrs",
+	"Coptic":                 "Copt",
+	"Cuneiform":              "Xsux",
+	"Cypriot":                "Cprt",
+	"Cypro_Minoan":           "Cpmn",
+	"Cyrillic":               "Cyrl",
+	"Deseret":                "Dsrt",
+	"Devanagari":             "Deva",
+	"Dives_Akuru":            "Diak",
+	"Dogra":                  "Dogr",
+	"Duployan":               "Dupl",
+	"Egyptian_Hieroglyphs":   "Egyp",
+	"Elbasan":                "Elba",
+	"Elymaic":                "Elym",
+	"Ethiopic":               "Ethi",
+	"Garay":                  "Gara",
+	"Georgian":               "Geor",
+	"Glagolitic":             "Glag",
+	"Gothic":                 "Goth",
+	"Grantha":                "Gran",
+	"Greek":                  "Grek",
+	"Gujarati":               "Gujr",
+	"Gunjala_Gondi":          "Gong",
+	"Gurmukhi":               "Guru",
+	"Gurung_Khema":           "Gukh",
+	"Han":                    "Hani",
+	"Hangul":                 "Hang",
+	"Hanifi_Rohingya":        "Rohg",
+	"Hanunoo":                "Hano",
+	"Hatran":                 "Hatr",
+	"Hebrew":                 "Hebr",
+	"Hiragana":               "Hira",
+	"Imperial_Aramaic":       "Armi",
+	"Inscriptional_Pahlavi":  "Phli",
+	"Inscriptional_Parthian": "Prti",
+	"Javanese":               "Java",
+	"Kaithi":                 "Kthi",
+	"Kannada":                "Knda",
+	"Katakana":               "Kana",
+	"Kawi":                   "Kawi",
+	"Kayah_Li":               "Kali",
+	"Kharoshthi":             "Khar",
+	"Khitan_Small_Script":    "Kits",
+	"Khmer":                  "Khmr",
+	"Khojki":                 "Khoj",
+	"Khudawadi":              "Sind",
+	"Kirat_Rai":              "Krai",
+	"Lao":                    "Laoo",
+	"Latin":                  "Latn",
+	"Lepcha":                 "Lepc",
+	"Limbu":                  "Limb",
+	"Linear_A":               "Lina",
+	"Linear_B":               "Linb",
+	"Lisu":                   "Lisu",
+	"Lycian":                 "Lyci",
+	"Lydian":                 "Lydi",
+	"Mahajani":               "Mahj",
+	"Makasar":                "Maka",
+	"Malayalam":              "Mlym",
+	"Mandaic":                "Mand",
+	"Manichaean":             "Mani",
+	"Marchen":                "Marc",
+	"Masaram_Gondi":          "Gonm",
+	"Medefaidrin":            "Medf",
+	"Meetei_Mayek":           "Mtei",
+	"Mende_Kikakui":          "Mend",
+	"Meroitic_Cursive":       "Merc",
+	"Meroitic_Hieroglyphs":   "Mero",
+	"Miao":                   "Plrd",
+	"Modi":                   "Modi",
+	"Mongolian":              "Mong",
+	"Mro":                    "Mroo",
+	"Multani":                "Mult",
+	"Myanmar":                "Mymr",
+	"Nabataean":              "Nbat",
+	"Nag_Mundari":            "Nagm",
+	"Nandinagari":            "Nand",
+	"New_Tai_Lue":            "Talu",
+	"Newa":                   "Newa",
+	"Nko":                    "Nkoo",
+	"Nushu":                  "Nshu",
+	"Nyiakeng_Puachue_Hmong": "Hmnp",
+	"Ogham":                  "Ogam",
+	"Ol_Chiki":               "Olck",
+	"Ol_Onal":                "Onao",
+	"Old_Hungarian":          "Hung",
+	"Old_Italic":             "Ital",
+	"Old_North_Arabian":      "Narb",
+	"Old_Permic":             "Perm",
+	"Old_Persian":            "Xpeo",
+	"Old_Sogdian":            "Sogo",
+	"Old_South_Arabian":      "Sarb",
+	"Old_Turkic":             "Orkh",
+	"Old_Uyghur":             "Ougr",
+	"Oriya":                  "Orya",
+	"Osage":                  "Osge",
+	"Osmanya":                "Osma",
+	"Pahawh_Hmong":           "Hmng",
+	"Palmyrene":              "Palm",
+	"Pau_Cin_Hau":            "Pauc",
+	"Phags_Pa":               "Phag",
+	"Phoenician":             "Phnx",
+	"Psalter_Pahlavi":        "Phlp",
+	"Rejang":                 "Rjng",
+	"Runic":                  "Runr",
+	"Samaritan":              "Samr",
+	"Saurashtra":             "Saur",
+	"Sharada":                "Shrd",
+	"Shavian":                "Shaw",
+	"Siddham":                "Sidd",
+	"Sidetic":                "Sidt",
+	"SignWriting":            "Sgnw",
+	"Sinhala":                "Sinh",
+	"Sogdian":                "Sogd",
+	"Sora_Sompeng":           "Sora",
+	"Soyombo":                "Soyo",
+	"Sundanese":              "Sund",
+	"Sunuwar":                "Sunu",
+	"Syloti_Nagri":           "Sylo",
+	"Syriac":                 "Syrc",
+	"Tagalog":                "Tglg",
+	"Tagbanwa":               "Tagb",
+	"Tai_Le":                 "Tale",
+	"Tai_Tham":               "Lana",
+	"Tai_Viet":               "Tavt",
+	"Tai_Yo":                 "Tayo",
+	"Takri":                  "Takr",
+	"Tamil":                  "Taml",
+	"Tangsa":                 "Tnsa",
+	"Tangut":                 "Tang",
+	"Telugu":                 "Telu",
+	"Thaana":                 "Thaa",
+	"Thai":                   "Thai",
+	"Tibetan":                "Tibt",
+	"Tifinagh":               "Tfng",
+	"Tirhuta":                "Tirh",
+	"Todhri":                 "Todr",
+	"Tolong_Siki":            "Tols",
+	"Toto":                   "Toto",
+	"Tulu_Tigalari":          "Tutg",
+	"Ugaritic":               "Ugar",
+	"Vai":                    "Vaii",
+	"Vithkuqi":               "Vith",
+	"Wancho":                 "Wcho",
+	"Warang_Citi":            "Wara",
+	"Yezidi":                 "Yezi",
+	"Yi":                     "Yiii",
+	"Zanabazar_Square":       "Zanb",
+}
+
+type script struct {
+	code  string
+	table *unicode.RangeTable
+}
+
+// The scripts we test for, with the most frequent ones at the front
+// so that the typical name needs only a few table lookups.
+var scripts = func() []script {
+	frequent := []string{
+		"Latin", "Cyrillic", "Han", "Arabic", "Hebrew",
+		"Greek", "Katakana", "Hiragana", "Hangul",
+	}
+	rank := make(map[string]int, len(frequent))
+	for i, s := range frequent {
+		rank[s] = i + 1
+	}
+
+	result := make([]script, 0, len(scriptCodes))
+	names := make([]string, 0, len(scriptCodes))
+	for name, _ := range scriptCodes {
+		if _, ok := unicode.Scripts[name]; ok {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		ri, rj := rank[names[i]], rank[names[j]]
+		if ri != rj {
+			if ri == 0 || rj == 0 {
+				return rj == 0
+			}
+			return ri < rj
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		result = append(result, script{scriptCodes[name], unicode.Scripts[name]})
+	}
+	return result
+}()
+
+// Scripts returns the sorted ISO 15924 codes of the writing systems
+// used in a string. Characters that are shared across scripts, such as
+// spaces, punctuation or combining accents, do not count. If a string
+// consists of nothing but such characters, the result is "Zyyy".
+//
+// Because Japanese and Korean mix several Unicode scripts in normal
+// writing, the respective combinations get reported as "Jpan", "Hrkt"
+// or "Kore". Any result with more than one code is a mixed-script string.
+func Scripts(s string) []string {
+	found := make(map[string]struct{}, 2)
+	var last *script
+	for _, c := range s {
+		if last != nil && unicode.Is(last.table, c) {
+			continue
+		}
+		for i := range scripts {
+			if unicode.Is(scripts[i].table, c) {
+				last = &scripts[i]
+				found[last.code] = struct{}{}
+				break
+			}
+		}
+	}
+
+	if len(found) == 0 {
+		if len(s) == 0 {
+			return nil
+		}
+		return []string{"Zyyy"}
+	}
+
+	if len(found) > 1 {
+		if only(found, "Hira", "Kana") {
+			return []string{"Hrkt"}
+		}
+		if only(found, "Hani", "Hira", "Kana") {
+			return []string{"Jpan"}
+		}
+		if only(found, "Hang", "Hani") {
+			return []string{"Kore"}
+		}
+	}
+
+	result := make([]string, 0, len(found))
+	for code, _ := range found {
+		result = append(result, code)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Returns true if set contains no other codes than those passed.
+func only(set map[string]struct{}, codes ...string) bool {
+	n := 0
+	for _, code := range codes {
+		if _, ok := set[code]; ok {
+			n += 1
+		}
+	}
+	return n == len(set)
+}
@@ -0,0 +1,36 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScripts(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		want string
+	}{
+		{"", ""},
+		{"Weiss", "Latn"},
+		{"Müller-Lüdenscheidt", "Latn"},
+		{"Вайс", "Cyrl"},
+		{"וייס", "Hebr"},
+		{"وايس", "Arab"},
+		{"ヴァイス", "Kana"},
+		{"イーヴァル", "Kana"},
+		{"魏斯", "Hani"},
+		{"ひらがなカタカナ", "Hrkt"},
+		{"山田はなこ", "Jpan"},
+		{"김金", "Kore"},
+		{"Aлекс", "Cyrl;Latn"},
+		{"42", "Zyyy"},
+	} {
+		got := strings.Join(Scripts(tc.name), ";")
+		if got != tc.want {
+			t.Errorf("Scripts(%q): got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Spool is an append-only file of length-prefixed records. To resume
+// an interrupted run, a spool can be truncated back to the size that
+// was recorded in a checkpoint. Spools are not safe for concurrent use.
+type Spool struct {
+	file   *os.File
+	writer *bufio.Writer
+	buf    []byte
+	closed bool
+}
+
+// OpenSpool opens a spool file, creating it if needed. Callers must
+// call Truncate before writing, so that records from an earlier run
+// do not get mixed with new ones.
+func OpenSpool(path string) (*Spool, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Spool{file: file, writer: bufio.NewWriterSize(file, 1<<20)}, nil
+}
+
+func (s *Spool) Write(record []byte) error {
+	s.buf = binary.AppendUvarint(s.buf[:0], uint64(len(record)))
+	s.buf = append(s.buf, record...)
+	_, err := s.writer.Write(s.buf)
+	return err
+}
+
+// Sync writes the spooled records to stable storage and returns
+// the size of the spool file.
+func (s *Spool) Sync() (int64, error) {
+	if err := s.writer.Flush(); err != nil {
+		return 0, err
+	}
+	if err := s.file.Sync(); err != nil {
+		return 0, err
+	}
+	return s.file.Seek(0, io.SeekCurrent)
+}
+
+// Truncate drops everything that got spooled after a checkpoint.
+func (s *Spool) Truncate(size int64) error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < size {
+		return fmt.Errorf("%s: %d bytes, but checkpoint needs %d", s.file.Name(), info.Size(), size)
+	}
+	if err := s.file.Truncate(size); err != nil {
+		return err
+	}
+	_, err = s.file.Seek(size, io.SeekStart)
+	s.writer.Reset(s.file)
+	return err
+}
+
+// ReadAll calls a function for every record in the spool.
+func (s *Spool) ReadAll(fn func(record []byte) error) error {
+	if err := s.writer.Flush(); err != nil {
+		return err
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(s.file)
+	for {
+		size, err := binary.ReadUvarint(reader)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		record := make([]byte, size)
+		if _, err := io.ReadFull(reader, record); err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+}
+
+// Close closes the spool file. Calling Close again does nothing,
+// so callers can defer it for cleaning up after errors.
+func (s *Spool) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.file.Close()
+}
+
+func (s *Spool) Remove() error {
+	return os.Remove(s.file.Name())
+}
@@ -0,0 +1,52 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSpool(t *testing.T) {
+	spool, err := OpenSpool(filepath.Join(t.TempDir(), "foo.spool"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []string{"foo", "", "bar"} {
+		if err := spool.Write([]byte(r)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	size, err := spool.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := spool.Write([]byte("lost")); err != nil {
+		t.Fatal(err)
+	}
+	if err := spool.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	err = spool.ReadAll(func(record []byte) error {
+		got = append(got, string(record))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"foo", "", "bar"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Closing twice, such as from a deferred cleanup, is fine.
+	if err := spool.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := spool.Close(); err != nil {
+		t.Errorf("second Close: got %v, want nil", err)
+	}
+}
@@ -0,0 +1,224 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// The schema of the SQLite output. Items are keyed by their numeric
+// Wikidata ID, so Q167755 becomes 167755. Name types are the most
+// specific matched classes, such as Q11879590 (female given name).
+// The folded column is a key for matching, such as "muller" for "Müller".
+// The bearers of an item are the number of humans with that name.
+const sqliteSchema = `
+CREATE TABLE items (
+	id INTEGER PRIMARY KEY,
+	qid TEXT NOT NULL,
+	bearers INTEGER NOT NULL
+);
+
+CREATE TABLE languages (
+	id INTEGER PRIMARY KEY,
+	code TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE name_types (
+	id INTEGER PRIMARY KEY,
+	qid TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE names (
+	id INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	folded TEXT NOT NULL,
+	item INTEGER NOT NULL REFERENCES items(id),
+	source TEXT NOT NULL,
+	scripts TEXT NOT NULL,
+	writing_systems TEXT NOT NULL
+);
+
+CREATE TABLE name_languages (
+	name INTEGER NOT NULL REFERENCES names(id),
+	language INTEGER NOT NULL REFERENCES languages(id),
+	PRIMARY KEY (name, language)
+) WITHOUT ROWID;
+
+CREATE TABLE item_types (
+	item INTEGER NOT NULL REFERENCES items(id),
+	type INTEGER NOT NULL REFERENCES name_types(id),
+	PRIMARY KEY (item, type)
+) WITHOUT ROWID;
+
+CREATE VIRTUAL TABLE names_fts USING fts5(
+	name,
+	content='names',
+	content_rowid='id'
+);
+`
+
+// SQLiteWriter is a NameSink that stores names in an SQLite database,
+// with a full-text index on the name column. Because the database gets
+// built in one go and renamed into place afterwards, we switch off
+// journaling for speed.
+type SQLiteWriter struct {
+	db                 *sql.DB
+	tx                 *sql.Tx
+	insertItem         *sql.Stmt
+	insertName         *sql.Stmt
+	insertNameLanguage *sql.Stmt
+	insertItemType     *sql.Stmt
+	languages          map[string]int64
+	nameTypes          map[string]int64
+	closed             bool
+}
+
+func NewSQLiteWriter(path string) (*SQLiteWriter, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	w := &SQLiteWriter{
+		db:        db,
+		languages: make(map[string]int64, 500),
+		nameTypes: make(map[string]int64, 50),
+	}
+	if err := w.init(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *SQLiteWriter) init() error {
+	for _, pragma := range []string{
+		"PRAGMA journal_mode = OFF",
+		"PRAGMA synchronous = OFF",
+	} {
+		if _, err := w.db.Exec(pragma); err != nil {
+			return err
+		}
+	}
+
+	if _, err := w.db.Exec(sqliteSchema); err != nil {
+		return err
+	}
+
+	var err error
+	if w.tx, err = w.db.Begin(); err != nil {
+		return err
+	}
+
+	for _, s := range []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&w.insertItem, "INSERT OR IGNORE INTO items (id, qid, bearers) VALUES (?, ?, ?)"},
+		{&w.insertName, "INSERT INTO names (name, folded, item, source, scripts, writing_systems) VALUES (?, ?, ?, ?, ?, ?)"},
+		{&w.insertNameLanguage, "INSERT OR IGNORE INTO name_languages (name, language) VALUES (?, ?)"},
+		{&w.insertItemType, "INSERT OR IGNORE INTO item_types (item, type) VALUES (?, ?)"},
+	} {
+		if *s.stmt, err = w.tx.Prepare(s.query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *SQLiteWriter) WriteName(n *Name) error {
+	item, err := parseQID(n.ID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.insertItem.Exec(item, n.ID, n.Bearers); err != nil {
+		return err
+	}
+
+	res, err := w.insertName.Exec(n.Name, n.Folded, item, n.Source,
+		strings.Join(n.Scripts, ";"), strings.Join(n.WritingSystems, ";"))
+	if err != nil {
+		return err
+	}
+	nameID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	for _, lang := range n.Languages {
+		langID, err := w.lookup(w.languages, "languages", "code", lang)
+		if err != nil {
+			return err
+		}
+		if _, err := w.insertNameLanguage.Exec(nameID, langID); err != nil {
+			return err
+		}
+	}
+
+	for _, class := range n.Classes {
+		typeID, err := w.lookup(w.nameTypes, "name_types", "qid", class)
+		if err != nil {
+			return err
+		}
+		if _, err := w.insertItemType.Exec(item, typeID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// lookup returns the row ID for a value in a small dictionary table,
+// such as "languages", inserting a new row on first use.
+func (w *SQLiteWriter) lookup(cache map[string]int64, table, column, value string) (int64, error) {
+	if id, ok := cache[value]; ok {
+		return id, nil
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (?)", table, column)
+	res, err := w.tx.Exec(query, value)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	cache[value] = id
+	return id, nil
+}
+
+// Close commits the names, builds the full-text index and closes
+// the database.
+func (w *SQLiteWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	if err := w.tx.Commit(); err != nil {
+		w.db.Close()
+		return err
+	}
+
+	for _, stmt := range []string{
+		"INSERT INTO names_fts (names_fts) VALUES ('rebuild')",
+		"CREATE INDEX names_name ON names (name)",
+		"CREATE INDEX names_folded ON names (folded)",
+		"CREATE INDEX names_item ON names (item)",
+		"ANALYZE",
+	} {
+		if _, err := w.db.Exec(stmt); err != nil {
+			w.db.Close()
+			return err
+		}
+	}
+
+	return w.db.Close()
+}
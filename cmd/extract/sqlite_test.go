@@ -0,0 +1,77 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"database/sql"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSQLiteWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "names.sqlite")
+	s, err := NewSQLiteWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewNameWriter(io.Discard, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []Name{
+		{Name: "Müller", ID: "Q1", Languages: []string{"de", "en"}, Source: "label", Scripts: []string{"Latn"}, Classes: []string{"Q101352"}, Folded: "muller", Bearers: 4},
+		{Name: "Mueller", ID: "Q1", Languages: []string{"de"}, Source: "alias", Scripts: []string{"Latn"}, Classes: []string{"Q101352"}, Bearers: 4},
+		{Name: "Мюллер", ID: "Q1", Languages: []string{"ru"}, Source: "label", Scripts: []string{"Cyrl"}, Classes: []string{"Q101352"}, Bearers: 4},
+		{Name: "Astrid", ID: "Q167755", Languages: []string{"de", "sv"}, Source: "label", Scripts: []string{"Latn"}, WritingSystems: []string{"Q8229"}, Classes: []string{"Q11879590"}},
+	} {
+		if err := w.WriteName(&n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{"SELECT COUNT(*) FROM names", "4"},
+		{"SELECT COUNT(*) FROM items", "2"},
+		{"SELECT GROUP_CONCAT(code, ';') FROM (SELECT code FROM languages ORDER BY code)", "de;en;ru;sv"},
+		{"SELECT GROUP_CONCAT(qid, ';') FROM (SELECT qid FROM name_types ORDER BY qid)", "Q101352;Q11879590"},
+		{`SELECT GROUP_CONCAT(l.code, ';') FROM names n
+		  JOIN name_languages nl ON nl.name = n.id
+		  JOIN languages l ON l.id = nl.language
+		  WHERE n.name = 'Müller'`, "de;en"},
+		{`SELECT t.qid FROM items i
+		  JOIN item_types it ON it.item = i.id
+		  JOIN name_types t ON t.id = it.type
+		  WHERE i.qid = 'Q167755'`, "Q11879590"},
+		{"SELECT writing_systems FROM names WHERE name = 'Astrid'", "Q8229"},
+		{"SELECT name FROM names WHERE folded = 'muller'", "Müller"},
+		{"SELECT bearers FROM items WHERE qid = 'Q1'", "4"},
+		{`SELECT GROUP_CONCAT(n.name, ';') FROM names_fts
+		  JOIN names n ON n.id = names_fts.rowid
+		  WHERE names_fts MATCH 'müller OR мюллер'`, "Müller;Мюллер"},
+	} {
+		var got string
+		if err := db.QueryRow(tc.query).Scan(&got); err != nil {
+			t.Errorf("%s: %v", strings.TrimSpace(tc.query), err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s: got %q, want %q", strings.TrimSpace(tc.query), got, tc.want)
+		}
+	}
+}
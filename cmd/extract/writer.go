@@ -0,0 +1,158 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ExtractWriter writes the names of an output into its extract files:
+// a gzipped CSV file, plus one file for each additional format in the
+// output's configuration. All files get written under a temporary name
+// and renamed into place once complete. The CSV file comes last, so
+// that its presence tells that the extract is complete.
+type ExtractWriter struct {
+	paths      []string
+	file       *os.File
+	compressor *gzip.Writer
+	nameWriter *NameWriter
+	counter    *nameCounter
+}
+
+// NewExtractWriter returns an ExtractWriter that sorts the names with
+// a number of workers; zero means one per CPU.
+func NewExtractWriter(workdir string, config *OutputConfig, date time.Time, workers int) (*ExtractWriter, error) {
+	day := date.Format("20060102")
+	w := &ExtractWriter{counter: newNameCounter()}
+	sinks := make([]NameSink, 0, len(config.Formats)+1)
+	sinks = append(sinks, w.counter)
+	for _, format := range config.Formats {
+		// NDJSON has one line per item, not per name, so it gets
+		// written by Output and Updater, not by ExtractWriter.
+		if format == "ndjson" {
+			continue
+		}
+
+		path := filepath.Join(workdir, fmt.Sprintf("%s-%s.%s", config.Name, day, outputFormats[format]))
+		w.paths = append(w.paths, path)
+
+		// SQLite would open an existing database, not replace it.
+		if err := os.Remove(path + ".tmp"); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		var sink NameSink
+		var err error
+		switch format {
+		case "parquet":
+			sink, err = NewParquetWriter(path + ".tmp")
+		case "sqlite":
+			sink, err = NewSQLiteWriter(path + ".tmp")
+		}
+		if err != nil {
+			w.closeSinks(sinks)
+			w.removeTemp()
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	path := extractPath(workdir, config.Name, date)
+	w.paths = append(w.paths, path)
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		w.closeSinks(sinks)
+		w.removeTemp()
+		return nil, err
+	}
+	w.file = file
+
+	compressor, err := gzip.NewWriterLevel(file, 9)
+	if err != nil {
+		w.closeSinks(sinks)
+		w.Abort()
+		return nil, err
+	}
+	w.compressor = compressor
+
+	nameWriter, err := newNameWriter(compressor, sortConfig(workers), sinks...)
+	if err != nil {
+		w.closeSinks(sinks)
+		w.Abort()
+		return nil, err
+	}
+	w.nameWriter = nameWriter
+
+	return w, nil
+}
+
+// WriteName passes a name to the NameWriter, which sorts the names
+// before writing them into the extract files.
+func (w *ExtractWriter) WriteName(n *Name) error {
+	return w.nameWriter.WriteName(n)
+}
+
+// Paths returns the paths of the extract files, with the CSV file last.
+func (w *ExtractWriter) Paths() []string {
+	return w.paths
+}
+
+// Counts returns how many names have been written, and from how
+// many distinct items. The counts are final once Close has returned.
+func (w *ExtractWriter) Counts() (rows int64, items int64) {
+	return w.counter.rows, int64(len(w.counter.items))
+}
+
+func (w *ExtractWriter) Close() error {
+	if err := w.nameWriter.Close(); err != nil {
+		w.Abort()
+		return err
+	}
+
+	if err := w.compressor.Close(); err != nil {
+		w.Abort()
+		return err
+	}
+
+	if err := w.file.Close(); err != nil {
+		w.Abort()
+		return err
+	}
+
+	for _, path := range w.paths {
+		if err := os.Rename(path+".tmp", path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Abort closes the ExtractWriter after an error, and removes
+// its temporary files.
+func (w *ExtractWriter) Abort() {
+	if w.nameWriter != nil {
+		w.nameWriter.Close()
+	}
+	if w.file != nil {
+		w.file.Close()
+	}
+	w.removeTemp()
+}
+
+func (w *ExtractWriter) closeSinks(sinks []NameSink) {
+	for _, sink := range sinks {
+		sink.Close()
+	}
+}
+
+func (w *ExtractWriter) removeTemp() {
+	for _, path := range w.paths {
+		os.Remove(path + ".tmp")
+	}
+}
@@ -0,0 +1,59 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/brawer/wikidata-names/v2/internal/defaultconfig"
+)
+
+// Output is an extract that we offer for download. The list of outputs
+// comes from the same configuration file that is passed to the extractor.
+type Output struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Output names become part of file names and URL paths. The extractor
+// checks them in the same way.
+var outputNamePattern = regexp.MustCompile(`^[a-zA-Z\d_]+$`)
+
+// ReadOutputs reads the list of outputs from an extractor configuration
+// file. If path is empty, we return the outputs of the extractor's
+// built-in configuration.
+func ReadOutputs(path string) ([]Output, error) {
+	data, name := defaultconfig.JSON, "built-in config.json"
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+		name = path
+	}
+
+	var config struct {
+		Outputs []Output `json:"outputs"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if len(config.Outputs) == 0 {
+		return nil, fmt.Errorf("%s: no outputs configured", name)
+	}
+	names := make(map[string]bool, len(config.Outputs))
+	for _, o := range config.Outputs {
+		if !outputNamePattern.MatchString(o.Name) {
+			return nil, fmt.Errorf("%s: bad output name %q", name, o.Name)
+		}
+		if names[o.Name] {
+			return nil, fmt.Errorf("%s: duplicate output %q", name, o.Name)
+		}
+		names[o.Name] = true
+	}
+	return config.Outputs, nil
+}
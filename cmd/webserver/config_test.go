@@ -0,0 +1,46 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readDefaultOutputs returns the outputs of the built-in configuration.
+func readDefaultOutputs(t *testing.T) []Output {
+	outputs, err := ReadOutputs("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return outputs
+}
+
+func TestReadOutputs(t *testing.T) {
+	got := readDefaultOutputs(t)
+	if len(got) != 2 || got[0].Name != "familynames" || got[1].Name != "givennames" {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestReadOutputsErrors(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct{ config, want string }{
+		{`{"outputs": []}`, "no outputs configured"},
+		{`{"outputs": [{"name": "../x"}]}`, `bad output name "../x"`},
+		{`{"outputs": [{"name": "x"}, {"name": "x"}]}`, `duplicate output "x"`},
+		{`{"outputs": `, "unexpected end of JSON input"},
+	} {
+		path := filepath.Join(dir, "config.json")
+		if err := os.WriteFile(path, []byte(tc.config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := ReadOutputs(path)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want %q", tc.config, err, tc.want)
+		}
+	}
+}
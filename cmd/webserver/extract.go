@@ -1,28 +1,65 @@
 package main
 
 import (
-	"crypto/sha1" // not used for security, just for http etag
-	"encoding/base64"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
+// Extract is a file that can be downloaded. For the files of an output,
+// Rows and Items tell the number of names and the number of distinct
+// Wikidata items, if known from the manifest of the extractor run.
+// For the files that list added or removed names, Rows is the number
+// of changed names, and Previous the date of the extract they were
+// compared to. For the inflected forms, Rows is the number of forms.
 type Extract struct {
 	Path         string
 	Etag         string
 	LastModified time.Time
+	Rows         int64
+	Items        int64
+	Previous     string
 }
 
 type Extracts map[string]Extract
 
 var filePattern = regexp.MustCompile(`^([a-zA-Z\d_\-]+)-(\d{8})\.csv\.gz$`)
 
-func ListExtracts(path string) (Extracts, error) {
+// The file formats that the extractor can write in addition to
+// gzipped CSV, with the content type for serving them.
+var extraFormats = []string{"ndjson.gz", "parquet", "sqlite"}
+
+// The content types for serving the files, by file name suffix.
+// The first matching suffix wins, so the order matters when one
+// suffix ends with another.
+var contentTypes = []struct{ suffix, contentType string }{
+	{".ndjson.gz", "application/x-ndjson"},
+	{".csv.gz", "text/csv"},
+	{".json", "application/json"},
+	{".parquet", "application/vnd.apache.parquet"},
+	{".sqlite", "application/vnd.sqlite3"},
+}
+
+// contentType returns the content type for serving a file.
+func contentType(filename string) string {
+	for _, t := range contentTypes {
+		if strings.HasSuffix(filename, t.suffix) {
+			return t.contentType
+		}
+	}
+	return ""
+}
+
+func ListExtracts(path string, outputs []Output) (Extracts, error) {
 	files, err := os.ReadDir(path)
 	if err != nil {
 		return nil, err
@@ -33,8 +70,8 @@ func ListExtracts(path string) (Extracts, error) {
 	for _, file := range files {
 		if m := filePattern.FindStringSubmatch(file.Name()); m != nil {
 			datesMap[m[2]] = true
-			dirEntries[file.Name()] = file
 		}
+		dirEntries[file.Name()] = file
 	}
 
 	dates := make([]string, 0, len(datesMap))
@@ -43,7 +80,10 @@ func ListExtracts(path string) (Extracts, error) {
 	}
 	sort.Sort(sort.Reverse(sort.StringSlice(dates)))
 	var date string
-	dumpNames := []string{"givennames", "familynames"}
+	dumpNames := make([]string, 0, len(outputs))
+	for _, o := range outputs {
+		dumpNames = append(dumpNames, o.Name)
+	}
 	for _, date = range dates {
 		allDumpsPresentOnDate := true
 		for _, dump := range dumpNames {
@@ -54,31 +94,171 @@ func ListExtracts(path string) (Extracts, error) {
 			}
 		}
 		if allDumpsPresentOnDate {
-			extracts := make(Extracts, len(dumpNames))
-			for _, dump := range dumpNames {
-				fileName := fmt.Sprintf("%s-%s.csv.gz", dump, date)
+			// Without a usable manifest, we can still serve the
+			// extracts; we just have to hash them ourselves.
+			m, err := readManifest(path, date)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				m = &manifest{}
+			}
+
+			// After an incremental update, only the files of the
+			// outputs are new. The files that only full runs write
+			// are those of the run that the update started from.
+			baseDate, base := date, m
+			if d, err := time.Parse("2006-01-02", m.BaseDate); err == nil && d.Format("20060102") != date {
+				baseDate = d.Format("20060102")
+				if base, err = readManifest(path, baseDate); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					base = &manifest{}
+				}
+			}
+
+			extracts := make(Extracts, len(dumpNames)+1)
+			newExtract := func(fileName string, files []manifestFile) (Extract, error) {
 				info, err := dirEntries[fileName].Info()
 				if err != nil {
-					return nil, err
+					return Extract{}, err
 				}
 				filePath := filepath.Join(path, fileName)
-				fileHash, err := hashFile(filePath)
-				if err != nil {
-					return nil, err
+
+				// The manifest has checksums of the files written by
+				// the extractor, so we only need to hash the files
+				// that it does not describe, such as for extracts that
+				// were produced before there were any manifests.
+				var etag string
+				for _, f := range files {
+					if f.Name == fileName && f.Size == info.Size() {
+						etag = f.SHA256
+					}
 				}
-				key := fmt.Sprintf("%s.csv.gz", dump)
-				extracts[key] = Extract{
+				if etag == "" {
+					if etag, err = hashes.get(filePath, info); err != nil {
+						return Extract{}, err
+					}
+				}
+
+				return Extract{
 					Path:         filePath,
-					Etag:         fileHash,
+					Etag:         etag,
 					LastModified: info.ModTime(),
+				}, nil
+			}
+
+			for _, dump := range dumpNames {
+				output, baseOutput := m.output(dump), base.output(dump)
+				fileName := fmt.Sprintf("%s-%s.csv.gz", dump, date)
+				e, err := newExtract(fileName, output.Files)
+				if err != nil {
+					return nil, err
+				}
+				e.Rows, e.Items = output.Rows, output.Items
+				extracts[fmt.Sprintf("%s.csv.gz", dump)] = e
+
+				// Other formats are optional, depending on the
+				// extractor configuration.
+				for _, format := range extraFormats {
+					fileName := fmt.Sprintf("%s-%s.%s", dump, date, format)
+					if _, present := dirEntries[fileName]; !present {
+						continue
+					}
+					e, err := newExtract(fileName, output.Files)
+					if err != nil {
+						return nil, err
+					}
+					e.Rows, e.Items = output.Rows, output.Items
+					extracts[fmt.Sprintf("%s.%s", dump, format)] = e
+				}
+
+				// The names that were added or removed since the
+				// previous extract, if the extractor found one.
+				for _, change := range []struct {
+					kind string
+					rows int64
+				}{{"added", baseOutput.Added}, {"removed", baseOutput.Removed}} {
+					fileName := fmt.Sprintf("%s-%s-%s.csv.gz", dump, change.kind, baseDate)
+					if _, present := dirEntries[fileName]; !present {
+						continue
+					}
+					e, err := newExtract(fileName, baseOutput.Files)
+					if err != nil {
+						return nil, err
+					}
+					e.Rows, e.Previous = change.rows, baseOutput.PreviousDate
+					extracts[fmt.Sprintf("%s-%s.csv.gz", dump, change.kind)] = e
+				}
+
+				// The inflected forms of the names, if the extractor
+				// had a lexemes dump.
+				fileName = fmt.Sprintf("%s-forms-%s.csv.gz", dump, baseDate)
+				if _, present := dirEntries[fileName]; present {
+					e, err := newExtract(fileName, baseOutput.Files)
+					if err != nil {
+						return nil, err
+					}
+					e.Rows = baseOutput.Forms
+					extracts[fmt.Sprintf("%s-forms.csv.gz", dump)] = e
 				}
 			}
+
+			// Files that are not specific to one output. The manifest
+			// cannot contain its own checksum, so it always gets hashed.
+			shared := base.sharedFiles()
+			for _, name := range sharedFiles {
+				day := baseDate
+				if name.base == "manifest" {
+					day = date
+				}
+				fileName := fmt.Sprintf("%s-%s.%s", name.base, day, name.ext)
+				if _, present := dirEntries[fileName]; !present {
+					continue
+				}
+				e, err := newExtract(fileName, shared)
+				if err != nil {
+					return nil, err
+				}
+				extracts[name.base+"."+name.ext] = e
+			}
 			return extracts, nil
 		}
 	}
 	return make(Extracts, 0), nil
 }
 
+// hashCache remembers the hashes of files that are not described by
+// a manifest, so that refreshing the list of extracts does not read
+// multi-gigabyte files again. A file that gets replaced, and thus
+// changes its size or modification time, is hashed anew.
+type hashCache struct {
+	mutex  sync.Mutex
+	hashes map[string]cachedHash
+}
+
+type cachedHash struct {
+	size    int64
+	modTime time.Time
+	hash    string
+}
+
+var hashes = &hashCache{hashes: make(map[string]cachedHash)}
+
+// get returns the hash of a file, computing it if needed.
+func (c *hashCache) get(path string, info os.FileInfo) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	h, ok := c.hashes[path]
+	if ok && h.size == info.Size() && h.modTime.Equal(info.ModTime()) {
+		return h.hash, nil
+	}
+	hash, err := hashFile(path)
+	if err != nil {
+		return "", err
+	}
+	c.hashes[path] = cachedHash{info.Size(), info.ModTime(), hash}
+	return hash, nil
+}
+
 func hashFile(path string) (string, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -86,7 +266,7 @@ func hashFile(path string) (string, error) {
 	}
 	defer f.Close()
 
-	hash := sha1.New()
+	hash := sha256.New()
 	if _, err := io.Copy(hash, f); err != nil {
 		return "", err
 	}
@@ -95,5 +275,85 @@ func hashFile(path string) (string, error) {
 		return "", err
 	}
 
-	return base64.StdEncoding.EncodeToString(hash.Sum(nil)), nil
+	// Same encoding as the checksums in the manifest, so that
+	// all our ETags look alike.
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// manifest is the part of the extractor's manifest-YYYYMMDD.json
+// that the webserver needs.
+type manifest struct {
+	BaseDate      string           `json:"base_date"`
+	Outputs       []manifestOutput `json:"outputs"`
+	CountriesFile *manifestFile    `json:"countries_file"`
+	GendersFile   *manifestFile    `json:"genders_file"`
+	DecadesFile   *manifestFile    `json:"decades_file"`
+	PersonsFile   *manifestFile    `json:"persons_file"`
+}
+
+// The files that are not specific to one output, such as
+// "name-countries-20230518.csv.gz", in the order we list them.
+var sharedFiles = []struct{ base, ext string }{
+	{"manifest", "json"},
+	{"name-countries", "csv.gz"},
+	{"name-decades", "csv.gz"},
+	{"name-genders", "csv.gz"},
+	{"persons", "csv.gz"},
+}
+
+type manifestFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type manifestOutput struct {
+	Name         string         `json:"name"`
+	Rows         int64          `json:"rows"`
+	Items        int64          `json:"items"`
+	PreviousDate string         `json:"previous_date"`
+	Added        int64          `json:"added"`
+	Removed      int64          `json:"removed"`
+	Forms        int64          `json:"forms"`
+	Files        []manifestFile `json:"files"`
+}
+
+// readManifest reads the manifest for the extracts of a date.
+// If there is none, it returns an empty manifest.
+func readManifest(path string, date string) (*manifest, error) {
+	manifestPath := filepath.Join(path, fmt.Sprintf("manifest-%s.json", date))
+	data, err := os.ReadFile(manifestPath)
+	if os.IsNotExist(err) {
+		return &manifest{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %v", manifestPath, err)
+	}
+	return &m, nil
+}
+
+// sharedFiles returns what the manifest says about the files
+// that are not specific to one output.
+func (m *manifest) sharedFiles() []manifestFile {
+	var files []manifestFile
+	for _, f := range []*manifestFile{m.CountriesFile, m.GendersFile, m.DecadesFile, m.PersonsFile} {
+		if f != nil {
+			files = append(files, *f)
+		}
+	}
+	return files
+}
+
+// output returns what the manifest says about an output,
+// or an empty description if it does not cover that output.
+func (m *manifest) output(name string) *manifestOutput {
+	for i := range m.Outputs {
+		if m.Outputs[i].Name == name {
+			return &m.Outputs[i]
+		}
+	}
+	return &manifestOutput{}
 }
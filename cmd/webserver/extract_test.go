@@ -24,7 +24,7 @@ func TestListExtracts(t *testing.T) {
 		}
 	}
 
-	gotMap, err := ListExtracts(dir)
+	gotMap, err := ListExtracts(dir, readDefaultOutputs(t))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -38,9 +38,386 @@ func TestListExtracts(t *testing.T) {
 	sort.Strings(gotVec)
 	got := strings.Join(gotVec, ", ")
 
-	want := `familynames.csv.gz:{Path=familynames-20230518.csv.gz, Etag=whORxBnAgoUsC45ZMnimRLe6JI0=}, givennames.csv.gz:{Path=givennames-20230518.csv.gz, Etag=YtG04r85Xl65fctqwQ0FFUwdYzA=}`
+	want := `familynames.csv.gz:{Path=familynames-20230518.csv.gz, Etag=20af04520b6ae8ffd8462d5c909411db6a136c47e68084a4ce5e5666b6ae6ec1}, givennames.csv.gz:{Path=givennames-20230518.csv.gz, Etag=df164dc4144cd8a9dcb2ef48cf6e2bce139e78e34e3fabb2f361f57c26ff9f19}`
 
 	if got != want {
 		t.Errorf("got %s, want %s", got, want)
 	}
 }
+
+func TestListExtractsConfigured(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"familynames-20230518.csv.gz",
+		"givennames-20230518.csv.gz",
+		"nicknames-20230131.csv.gz",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	outputs := []Output{{Name: "givennames"}, {Name: "nicknames"}}
+	gotMap, err := ListExtracts(dir, outputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotVec := make([]string, 0, len(gotMap))
+	for k, v := range gotMap {
+		gotVec = append(gotVec, fmt.Sprintf("%s:%s", k, filepath.Base(v.Path)))
+	}
+	sort.Strings(gotVec)
+	got := strings.Join(gotVec, ", ")
+
+	// There is no givennames extract for 20230131, and no nicknames
+	// extract for 20230518. Therefore, no date has a complete set.
+	if got != "" {
+		t.Errorf("got %q, want empty", got)
+	}
+
+	name := "givennames-20230131.csv.gz"
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if gotMap, err = ListExtracts(dir, outputs); err != nil {
+		t.Fatal(err)
+	}
+	gotVec = gotVec[:0]
+	for k, v := range gotMap {
+		gotVec = append(gotVec, fmt.Sprintf("%s:%s", k, filepath.Base(v.Path)))
+	}
+	sort.Strings(gotVec)
+	got = strings.Join(gotVec, ", ")
+	want := "givennames.csv.gz:givennames-20230131.csv.gz, nicknames.csv.gz:nicknames-20230131.csv.gz"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestListExtractsFormats(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"familynames-20230131.parquet",
+		"familynames-20230518.csv.gz",
+		"givennames-20230518.csv.gz",
+		"givennames-20230518.ndjson.gz",
+		"givennames-20230518.parquet",
+		"givennames-20230518.sqlite",
+		"givennames-20230518.xlsx",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gotMap, err := ListExtracts(dir, readDefaultOutputs(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotVec := make([]string, 0, len(gotMap))
+	for k, v := range gotMap {
+		gotVec = append(gotVec, fmt.Sprintf("%s:%s", k, filepath.Base(v.Path)))
+	}
+	sort.Strings(gotVec)
+	got := strings.Join(gotVec, ", ")
+	want := "familynames.csv.gz:familynames-20230518.csv.gz, " +
+		"givennames.csv.gz:givennames-20230518.csv.gz, " +
+		"givennames.ndjson.gz:givennames-20230518.ndjson.gz, " +
+		"givennames.parquet:givennames-20230518.parquet, " +
+		"givennames.sqlite:givennames-20230518.sqlite"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestListExtractsManifest(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"familynames-20230518.csv.gz",
+		"givennames-20230518.csv.gz",
+		"givennames-20230518.parquet",
+		"name-countries-20230518.csv.gz",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The size of familynames-20230518.csv.gz does not match,
+	// so the webserver should not trust the manifest for that file.
+	manifest := `{"countries_file": {"name": "name-countries-20230518.csv.gz", "size": 30, "sha256": "dddd"},
+	"outputs": [
+		{"name": "givennames", "rows": 7, "items": 3, "files": [
+			{"name": "givennames-20230518.parquet", "size": 27, "sha256": "aaaa"},
+			{"name": "givennames-20230518.csv.gz", "size": 26, "sha256": "bbbb"}]},
+		{"name": "familynames", "rows": 5, "items": 2, "files": [
+			{"name": "familynames-20230518.csv.gz", "size": 1, "sha256": "cccc"}]}
+	]}`
+	if err := os.WriteFile(filepath.Join(dir, "manifest-20230518.json"), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	gotMap, err := ListExtracts(dir, readDefaultOutputs(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotVec := make([]string, 0, len(gotMap))
+	for k, v := range gotMap {
+		base := filepath.Base(v.Path)
+		s := fmt.Sprintf("%s:{Path=%s, Etag=%s, Rows=%d, Items=%d}", k, base, v.Etag, v.Rows, v.Items)
+		gotVec = append(gotVec, s)
+	}
+	sort.Strings(gotVec)
+	got := strings.Join(gotVec, ", ")
+
+	want := "familynames.csv.gz:{Path=familynames-20230518.csv.gz, Etag=20af04520b6ae8ffd8462d5c909411db6a136c47e68084a4ce5e5666b6ae6ec1, Rows=5, Items=2}, " +
+		"givennames.csv.gz:{Path=givennames-20230518.csv.gz, Etag=bbbb, Rows=7, Items=3}, " +
+		"givennames.parquet:{Path=givennames-20230518.parquet, Etag=aaaa, Rows=7, Items=3}, " +
+		"manifest.json:{Path=manifest-20230518.json, Etag="
+	if !strings.HasPrefix(got, want) {
+		t.Errorf("got %s, want %s...", got, want)
+	}
+	if !strings.HasSuffix(got, "name-countries.csv.gz:{Path=name-countries-20230518.csv.gz, Etag=dddd, Rows=0, Items=0}") {
+		t.Errorf("got %s, want name-countries.csv.gz with Etag=dddd", got)
+	}
+}
+
+func TestListExtractsBadManifest(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"familynames-20230518.csv.gz",
+		"givennames-20230518.csv.gz",
+		"manifest-20230518.json",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A manifest that cannot be parsed should not keep us from
+	// serving the extracts; they just get hashed.
+	got, err := ListExtracts(dir, readDefaultOutputs(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "df164dc4144cd8a9dcb2ef48cf6e2bce139e78e34e3fabb2f361f57c26ff9f19"
+	if etag := got["givennames.csv.gz"].Etag; etag != want {
+		t.Errorf("got Etag=%s, want %s", etag, want)
+	}
+	if _, ok := got["manifest.json"]; !ok {
+		t.Errorf("manifest.json missing from %v", got)
+	}
+}
+
+func TestListExtractsChanges(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"familynames-20230518.csv.gz",
+		"givennames-20230518.csv.gz",
+		"givennames-added-20230518.csv.gz",
+		"givennames-removed-20230518.csv.gz",
+		"givennames-added-20230511.csv.gz",
+		"familynames-forms-20230518.csv.gz",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	manifest := `{"outputs": [
+		{"name": "familynames", "forms": 3},
+		{"name": "givennames", "previous_date": "2023-05-11", "added": 4, "removed": 2}
+	]}`
+	if err := os.WriteFile(filepath.Join(dir, "manifest-20230518.json"), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	gotMap, err := ListExtracts(dir, readDefaultOutputs(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotVec := make([]string, 0, len(gotMap))
+	for k, v := range gotMap {
+		if strings.Contains(k, "-") {
+			s := fmt.Sprintf("%s:{Path=%s, Rows=%d, Previous=%s}", k, filepath.Base(v.Path), v.Rows, v.Previous)
+			gotVec = append(gotVec, s)
+		}
+	}
+	sort.Strings(gotVec)
+	got := strings.Join(gotVec, ", ")
+	want := "familynames-forms.csv.gz:{Path=familynames-forms-20230518.csv.gz, Rows=3, Previous=}, " +
+		"givennames-added.csv.gz:{Path=givennames-added-20230518.csv.gz, Rows=4, Previous=2023-05-11}, " +
+		"givennames-removed.csv.gz:{Path=givennames-removed-20230518.csv.gz, Rows=2, Previous=2023-05-11}"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestListExtractsSharedFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"familynames-20230518.csv.gz",
+		"givennames-20230518.csv.gz",
+		"manifest-20230518.json",
+		"name-countries-20230518.csv.gz",
+		"name-decades-20230518.csv.gz",
+		"name-genders-20230518.csv.gz",
+		"name-genders-20230511.csv.gz",
+		"persons-20230518.csv.gz",
+		"rejected-20230518.csv.gz",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gotMap, err := ListExtracts(dir, readDefaultOutputs(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var gotVec []string
+	for _, f := range sharedFiles {
+		name := f.base + "." + f.ext
+		if e, ok := gotMap[name]; ok {
+			gotVec = append(gotVec, fmt.Sprintf("%s:{Path=%s}", name, filepath.Base(e.Path)))
+		}
+	}
+	got := strings.Join(gotVec, ", ")
+	want := "manifest.json:{Path=manifest-20230518.json}, " +
+		"name-countries.csv.gz:{Path=name-countries-20230518.csv.gz}, " +
+		"name-decades.csv.gz:{Path=name-decades-20230518.csv.gz}, " +
+		"name-genders.csv.gz:{Path=name-genders-20230518.csv.gz}, " +
+		"persons.csv.gz:{Path=persons-20230518.csv.gz}"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, ok := gotMap["rejected.csv.gz"]; ok {
+		t.Error("rejected.csv.gz should not be offered for download")
+	}
+}
+
+// After a full run, the incremental updates of the following days only
+// write the extracts of the outputs, and a manifest that tells which
+// full run the other files come from.
+func TestListExtractsIncremental(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"familynames-20230518.csv.gz",
+		"familynames-forms-20230518.csv.gz",
+		"givennames-20230518.csv.gz",
+		"givennames-added-20230518.csv.gz",
+		"givennames-removed-20230518.csv.gz",
+		"name-countries-20230518.csv.gz",
+		"persons-20230518.csv.gz",
+		"familynames-20230519.csv.gz",
+		"givennames-20230519.csv.gz",
+		"givennames-20230519.ndjson.gz",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	full := `{"dump_date": "2023-05-18",
+	"countries_file": {"name": "name-countries-20230518.csv.gz", "size": 30, "sha256": "aaaa"},
+	"persons_file": {"name": "persons-20230518.csv.gz", "size": 23, "sha256": "bbbb"},
+	"outputs": [
+		{"name": "familynames", "rows": 5, "items": 2, "forms": 3, "files": [
+			{"name": "familynames-20230518.csv.gz", "size": 27, "sha256": "cccc"},
+			{"name": "familynames-forms-20230518.csv.gz", "size": 33, "sha256": "dddd"}]},
+		{"name": "givennames", "rows": 7, "items": 3, "previous_date": "2023-05-11", "added": 4, "removed": 2, "files": [
+			{"name": "givennames-20230518.csv.gz", "size": 26, "sha256": "eeee"},
+			{"name": "givennames-added-20230518.csv.gz", "size": 32, "sha256": "ffff"},
+			{"name": "givennames-removed-20230518.csv.gz", "size": 34, "sha256": "gggg"}]}
+	]}`
+	incremental := `{"dump_date": "2023-05-19", "base_date": "2023-05-18",
+	"outputs": [
+		{"name": "familynames", "rows": 6, "items": 3, "files": [
+			{"name": "familynames-20230519.csv.gz", "size": 27, "sha256": "hhhh"}]},
+		{"name": "givennames", "rows": 8, "items": 4, "files": [
+			{"name": "givennames-20230519.csv.gz", "size": 26, "sha256": "iiii"},
+			{"name": "givennames-20230519.ndjson.gz", "size": 29, "sha256": "jjjj"}]}
+	]}`
+	for name, data := range map[string]string{
+		"manifest-20230518.json": full,
+		"manifest-20230519.json": incremental,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gotMap, err := ListExtracts(dir, readDefaultOutputs(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotVec := make([]string, 0, len(gotMap))
+	for k, v := range gotMap {
+		if k == "manifest.json" {
+			gotVec = append(gotVec, fmt.Sprintf("%s:{Path=%s}", k, filepath.Base(v.Path)))
+			continue
+		}
+		s := fmt.Sprintf("%s:{Path=%s, Etag=%s, Rows=%d, Items=%d, Previous=%s}",
+			k, filepath.Base(v.Path), v.Etag, v.Rows, v.Items, v.Previous)
+		gotVec = append(gotVec, s)
+	}
+	sort.Strings(gotVec)
+	got := strings.Join(gotVec, "\n")
+	want := "familynames-forms.csv.gz:{Path=familynames-forms-20230518.csv.gz, Etag=dddd, Rows=3, Items=0, Previous=}\n" +
+		"familynames.csv.gz:{Path=familynames-20230519.csv.gz, Etag=hhhh, Rows=6, Items=3, Previous=}\n" +
+		"givennames-added.csv.gz:{Path=givennames-added-20230518.csv.gz, Etag=ffff, Rows=4, Items=0, Previous=2023-05-11}\n" +
+		"givennames-removed.csv.gz:{Path=givennames-removed-20230518.csv.gz, Etag=gggg, Rows=2, Items=0, Previous=2023-05-11}\n" +
+		"givennames.csv.gz:{Path=givennames-20230519.csv.gz, Etag=iiii, Rows=8, Items=4, Previous=}\n" +
+		"givennames.ndjson.gz:{Path=givennames-20230519.ndjson.gz, Etag=jjjj, Rows=8, Items=4, Previous=}\n" +
+		"manifest.json:{Path=manifest-20230519.json}\n" +
+		"name-countries.csv.gz:{Path=name-countries-20230518.csv.gz, Etag=aaaa, Rows=0, Items=0, Previous=}\n" +
+		"persons.csv.gz:{Path=persons-20230518.csv.gz, Etag=bbbb, Rows=0, Items=0, Previous=}"
+	if got != want {
+		t.Errorf("got\n%s\n\nwant\n%s", got, want)
+	}
+}
+
+func TestContentType(t *testing.T) {
+	for _, tc := range []struct{ filename, want string }{
+		{"givennames.csv.gz", "text/csv"},
+		{"givennames.ndjson.gz", "application/x-ndjson"},
+		{"givennames.parquet", "application/vnd.apache.parquet"},
+		{"givennames.sqlite", "application/vnd.sqlite3"},
+		{"manifest.json", "application/json"},
+		{"robots.txt", ""},
+	} {
+		if got := contentType(tc.filename); got != tc.want {
+			t.Errorf("contentType(%q) = %q, want %q", tc.filename, got, tc.want)
+		}
+	}
+}
+
+func TestHashCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "givennames-20230518.parquet")
+	if err := os.WriteFile(path, []byte("foo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &hashCache{hashes: make(map[string]cachedHash)}
+	want, err := c.get(path, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// As long as size and modification time are unchanged,
+	// the file should not be read again.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.get(path, info); err != nil || got != want {
+		t.Errorf("got (%q, %v), want (%q, nil)", got, err, want)
+	}
+}
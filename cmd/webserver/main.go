@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -9,17 +10,26 @@ import (
 )
 
 func main() {
+	var configPath = flag.String("config", "", "path to extractor configuration file, or empty for the built-in default")
+	flag.Parse()
+
 	port, err := strconv.Atoi(os.Getenv("PORT"))
 	if err != nil {
 		port = 8080
 	}
 
-	server, err := NewServer(".")
+	outputs, err := ReadOutputs(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server, err := NewServer(".", outputs)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	http.HandleFunc("/", server.HandleHomepage)
+	http.HandleFunc("/changes", server.HandleChanges)
 	http.HandleFunc("/downloads/", server.HandleDownload)
 	http.HandleFunc("/robots.txt", server.HandleRobotsTxt)
 	if err := http.ListenAndServe(fmt.Sprintf(":%d", port), nil); err != nil {
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"embed"
 	"fmt"
+	"html/template"
 	"net/http"
 	"os"
 	"strings"
@@ -10,11 +12,16 @@ import (
 	"time"
 )
 
-//go:embed homepage.html
+//go:embed homepage.html changes.html style.html
 var content embed.FS
 
+// Both pages share the fonts and the style sheet from style.html.
+var homepage = template.Must(template.ParseFS(content, "homepage.html", "style.html"))
+var changesPage = template.Must(template.ParseFS(content, "changes.html", "style.html"))
+
 type Server struct {
 	workdir string
+	outputs []Output
 
 	ticker     *time.Ticker
 	tickerDone chan bool
@@ -23,8 +30,8 @@ type Server struct {
 	extracts Extracts
 }
 
-func NewServer(workdir string) (*Server, error) {
-	extracts, err := ListExtracts(workdir)
+func NewServer(workdir string, outputs []Output) (*Server, error) {
+	extracts, err := ListExtracts(workdir, outputs)
 	if err != nil {
 		return nil, err
 	}
@@ -32,6 +39,7 @@ func NewServer(workdir string) (*Server, error) {
 	ticker := time.NewTicker(15 * 60 * time.Second)
 	server := Server{
 		workdir:    workdir,
+		outputs:    outputs,
 		extracts:   extracts,
 		ticker:     ticker,
 		tickerDone: make(chan bool),
@@ -58,14 +66,92 @@ func (self *Server) HandleHomepage(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	page, err := content.ReadFile("homepage.html")
-	if err != nil {
+	// For every output, the files other than CSV that can
+	// currently be downloaded, such as "givennames.parquet"
+	// or "givennames-forms.csv.gz".
+	type homepageOutput struct {
+		Output
+		Formats []string
+		Rows    int64
+		Items   int64
+	}
+	self.mutex.RLock()
+	outputs := make([]homepageOutput, 0, len(self.outputs))
+	for _, o := range self.outputs {
+		csv := self.extracts[o.Name+".csv.gz"]
+		ho := homepageOutput{Output: o, Rows: csv.Rows, Items: csv.Items}
+		for _, format := range extraFormats {
+			fileName := fmt.Sprintf("%s.%s", o.Name, format)
+			if _, ok := self.extracts[fileName]; ok {
+				ho.Formats = append(ho.Formats, fileName)
+			}
+		}
+		if _, ok := self.extracts[o.Name+"-forms.csv.gz"]; ok {
+			ho.Formats = append(ho.Formats, o.Name+"-forms.csv.gz")
+		}
+		outputs = append(outputs, ho)
+	}
+
+	// The files that are not specific to one output, such as
+	// "name-countries.csv.gz", if they can currently be downloaded.
+	var shared []string
+	for _, f := range sharedFiles {
+		fileName := f.base + "." + f.ext
+		if _, ok := self.extracts[fileName]; ok {
+			shared = append(shared, fileName)
+		}
+	}
+	self.mutex.RUnlock()
+
+	data := struct {
+		Outputs []homepageOutput
+		Shared  []string
+	}{outputs, shared}
+	var page bytes.Buffer
+	if err := homepage.Execute(&page, data); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html;charset=utf-8")
+	if _, err := w.Write(page.Bytes()); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// HandleChanges serves a page that summarizes which names have been
+// added or removed since the previous extract.
+func (self *Server) HandleChanges(w http.ResponseWriter, req *http.Request) {
+	type changes struct {
+		Name     string
+		Previous string
+		Added    *Extract
+		Removed  *Extract
+	}
+	self.mutex.RLock()
+	outputs := make([]changes, 0, len(self.outputs))
+	for _, o := range self.outputs {
+		c := changes{Name: o.Name}
+		if e, ok := self.extracts[o.Name+"-added.csv.gz"]; ok {
+			c.Added, c.Previous = &e, e.Previous
+		}
+		if e, ok := self.extracts[o.Name+"-removed.csv.gz"]; ok {
+			c.Removed, c.Previous = &e, e.Previous
+		}
+		if c.Added != nil || c.Removed != nil {
+			outputs = append(outputs, c)
+		}
+	}
+	self.mutex.RUnlock()
+
+	var page bytes.Buffer
+	if err := changesPage.Execute(&page, outputs); err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "text/html;charset=utf-8")
-	if _, err := w.Write(page); err != nil {
+	if _, err := w.Write(page.Bytes()); err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
@@ -88,7 +174,9 @@ func (self *Server) HandleDownload(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/csv")
+	if t := contentType(filename); t != "" {
+		w.Header().Set("Content-Type", t)
+	}
 	w.Header().Set("ETag", extract.Etag)
 	http.ServeContent(w, req, filename, extract.LastModified, f)
 }
@@ -99,7 +187,7 @@ func (self *Server) HandleRobotsTxt(w http.ResponseWriter, req *http.Request) {
 }
 
 func (self *Server) refreshExtracts() error {
-	extracts, err := ListExtracts(self.workdir)
+	extracts, err := ListExtracts(self.workdir, self.outputs)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleHomepage(t *testing.T) {
+	server := &Server{
+		outputs: []Output{{Name: "givennames"}},
+		extracts: Extracts{
+			"givennames.csv.gz":     Extract{Rows: 7, Items: 3},
+			"name-genders.csv.gz":   Extract{},
+			"name-countries.csv.gz": Extract{},
+		},
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	server.HandleHomepage(w, req)
+
+	body := w.Body.String()
+	for _, want := range []string{
+		`<a href="/downloads/givennames.csv.gz">givennames.csv.gz</a> (7 names of 3 items)`,
+		`<li><a href="/downloads/name-countries.csv.gz">name-countries.csv.gz</a></li>
+  <li><a href="/downloads/name-genders.csv.gz">name-genders.csv.gz</a></li>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("homepage does not contain %q; got %s", want, body)
+		}
+	}
+	if strings.Contains(body, "persons.csv.gz") {
+		t.Errorf("homepage links to missing persons.csv.gz; got %s", body)
+	}
+}
+
+func TestHandleChanges(t *testing.T) {
+	server := &Server{
+		outputs: []Output{{Name: "familynames"}, {Name: "givennames"}},
+		extracts: Extracts{
+			"familynames.csv.gz":        Extract{},
+			"givennames.csv.gz":         Extract{},
+			"givennames-added.csv.gz":   Extract{Rows: 4, Previous: "2023-05-11"},
+			"givennames-removed.csv.gz": Extract{Rows: 2, Previous: "2023-05-11"},
+		},
+	}
+	req := httptest.NewRequest("GET", "/changes", nil)
+	w := httptest.NewRecorder()
+	server.HandleChanges(w, req)
+
+	if got := w.Header().Get("Content-Type"); got != "text/html;charset=utf-8" {
+		t.Errorf("got Content-Type %q", got)
+	}
+	body := w.Body.String()
+	want := `<li>givennames, since 2023-05-11: ` +
+		`<a href="/downloads/givennames-added.csv.gz">4 added</a> · ` +
+		`<a href="/downloads/givennames-removed.csv.gz">2 removed</a></li>`
+	if !strings.Contains(body, want) {
+		t.Errorf("changes page does not contain %q; got %s", want, body)
+	}
+	if strings.Contains(body, "familynames") {
+		t.Errorf("changes page should not list familynames; got %s", body)
+	}
+	if !strings.Contains(body, "<style>") {
+		t.Errorf("changes page lacks the shared style sheet; got %s", body)
+	}
+}
+
+func TestHandleChangesNone(t *testing.T) {
+	server := &Server{
+		outputs:  []Output{{Name: "givennames"}},
+		extracts: Extracts{"givennames.csv.gz": Extract{}},
+	}
+	req := httptest.NewRequest("GET", "/changes", nil)
+	w := httptest.NewRecorder()
+	server.HandleChanges(w, req)
+
+	want := "did not find an earlier one to compare with"
+	if body := w.Body.String(); !strings.Contains(body, want) {
+		t.Errorf("changes page does not contain %q; got %s", want, body)
+	}
+}
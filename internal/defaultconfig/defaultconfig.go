@@ -0,0 +1,15 @@
+// SPDX-FileCopyrightText: 2023 Sascha Brawer <sascha@brawer.ch>
+// SPDX-License-Identifier: MIT
+
+// Package defaultconfig holds the built-in configuration of the
+// extractor. The webserver reads it too, for listing the outputs
+// that it offers for download.
+package defaultconfig
+
+import _ "embed"
+
+// JSON is the configuration that gets used when no -config flag
+// is passed to the extractor or to the webserver.
+//
+//go:embed config.json
+var JSON []byte